@@ -0,0 +1,453 @@
+package kvs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	ErrKeyExists    = errors.New("kvs: key already exists")
+	ErrPrecondition = errors.New("kvs: precondition failed")
+)
+
+// Replace the value at key with new, but only if it currently equals old.
+// The stored value is decoded into a fresh value of old's type and compared
+// with reflect.DeepEqual. A nil old means the key must be absent, so the
+// swap becomes an insert. Reports whether the swap happened.
+func (kvs *KVStore) CompareAndSwap(key string, old, new interface{}) (_ bool, err error) {
+	defer func() { kvs.logOp("CompareAndSwap", key, err) }()
+	data, err := kvs.encode(key, new)
+	if err != nil {
+		return false, err
+	}
+	swapped := false
+	err = kvs.update(func(tx *bolt.Tx) error {
+		if ok, err := kvs.holds(tx, key, old); err != nil || !ok {
+			return err
+		}
+		swapped = true
+		return kvs.put(tx, key, data)
+	})
+	return swapped, err
+}
+
+// Like CompareAndSwap, but comparing the bytes stored at key with old,
+// as GetRaw returns them, and storing new as PutRaw does, without going
+// through the Codec. A nil old means the key must be absent; an empty
+// non-nil one matches an empty value. new must not be nil.
+func (kvs *KVStore) CompareAndSwapRaw(key string, old, new []byte) (_ bool, err error) {
+	defer func() { kvs.logOp("CompareAndSwapRaw", key, err) }()
+	if new == nil {
+		return false, ErrBadValue
+	}
+	swapped := false
+	err = kvs.update(func(tx *bolt.Tx) error {
+		v, err := kvs.lookup(tx, key)
+		if err == ErrNotFound || err == errExpired {
+			if old != nil {
+				return nil
+			}
+		} else if err != nil {
+			return err
+		} else if old == nil || !bytes.Equal(v, old) {
+			return nil
+		}
+		swapped = true
+		return kvs.put(tx, key, new)
+	})
+	return swapped, err
+}
+
+// Report whether key currently holds old within tx, as CompareAndSwap
+// compares them: a nil old matches a missing or expired key.
+func (kvs *KVStore) holds(tx *bolt.Tx, key string, old interface{}) (bool, error) {
+	v, err := kvs.lookup(tx, key)
+	if err == errExpired || err == ErrNotFound {
+		return old == nil, nil
+	} else if err != nil {
+		return false, err
+	} else if old == nil {
+		return false, nil
+	}
+	current := reflect.New(reflect.TypeOf(old))
+	if err := kvs.decode(key, v, current.Interface()); err != nil {
+		return false, err
+	}
+	return reflect.DeepEqual(current.Elem().Interface(), old), nil
+}
+
+// Returned by Transact when a key didn't hold its expected value. It
+// matches ErrPrecondition with errors.Is.
+type PreconditionError struct {
+	Key string
+}
+
+func (e *PreconditionError) Error() string {
+	return fmt.Sprintf("kvs: precondition failed for %q", e.Key)
+}
+
+func (e *PreconditionError) Is(target error) bool {
+	return target == ErrPrecondition
+}
+
+// Check that every key in reads holds its expected value and, only if they
+// all do, apply writes, all in one transaction: CompareAndSwap over many
+// keys. Values are compared as CompareAndSwap does, so a nil expectation
+// means the key must be absent. Reads are checked in key order, and the
+// first mismatch is returned as a *PreconditionError naming its key, with
+// nothing written. A nil value in writes deletes that key; any other is
+// stored like Put, replacing its TTL. Every write is encoded before the
+// transaction starts, so a bad value fails without checking anything.
+func (kvs *KVStore) Transact(reads map[string]interface{}, writes map[string]interface{}) (err error) {
+	defer func() {
+		for key := range writes {
+			kvs.logOp("Transact", key, err)
+		}
+	}()
+	encoded := make(map[string][]byte, len(writes))
+	for key, value := range writes {
+		if value == nil {
+			encoded[key] = nil
+		} else if encoded[key], err = kvs.encode(key, value); err != nil {
+			return err
+		}
+	}
+	keys := make([]string, 0, len(reads))
+	for key := range reads {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return kvs.update(func(tx *bolt.Tx) error {
+		for _, key := range keys {
+			if ok, err := kvs.holds(tx, key, reads[key]); err != nil {
+				return err
+			} else if !ok {
+				return &PreconditionError{Key: key}
+			}
+		}
+		for key, data := range encoded {
+			var err error
+			if data == nil {
+				_, err = kvs.delete(tx, key)
+			} else {
+				err = kvs.put(tx, key, data)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Add delta to the int64 stored at key and return the new value, all in
+// one transaction. A missing key counts as 0. Values that don't decode as
+// an int64 return ErrBadValue. An existing TTL on key is kept.
+func (kvs *KVStore) Increment(key string, delta int64) (_ int64, err error) {
+	defer func() { kvs.logOp("Increment", key, err) }()
+	var n int64
+	err = kvs.update(func(tx *bolt.Tx) error {
+		var err error
+		n, err = kvs.increment(tx, key, delta)
+		return err
+	})
+	return n, err
+}
+
+// Like Increment for several counters at once, in one transaction, so
+// they stay consistent with each other. Returns the new value of every
+// key in deltas. If any of them holds something other than an int64,
+// nothing is written and the error, which matches ErrBadValue, names it.
+func (kvs *KVStore) IncrementMany(deltas map[string]int64) (_ map[string]int64, err error) {
+	defer func() {
+		for key := range deltas {
+			kvs.logOp("IncrementMany", key, err)
+		}
+	}()
+	values := make(map[string]int64, len(deltas))
+	err = kvs.update(func(tx *bolt.Tx) error {
+		for key, delta := range deltas {
+			n, err := kvs.increment(tx, key, delta)
+			if err == ErrBadValue {
+				return fmt.Errorf("kvs: %q is not an int64: %w", key, err)
+			} else if err != nil {
+				return err
+			}
+			values[key] = n
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// Add delta to the int64 at key within tx, returning the new value.
+func (kvs *KVStore) increment(tx *bolt.Tx, key string, delta int64) (int64, error) {
+	var n int64
+	v, err := kvs.lookup(tx, key)
+	if err == nil {
+		if err := kvs.codec.Unmarshal(v, &n); err != nil {
+			return 0, ErrBadValue
+		}
+	} else if err != ErrNotFound && err != errExpired {
+		return 0, err
+	}
+	n += delta
+	data, err := kvs.encode(key, n)
+	if err != nil {
+		return 0, err
+	}
+	if v != nil {
+		err = kvs.write(tx, key, data)
+	} else {
+		err = kvs.put(tx, key, data)
+	}
+	return n, err
+}
+
+// Decode the value at key into value, first storing the result of compute
+// if key is missing. compute only runs when the key is missing, inside the
+// write transaction, so it should be quick. An error from compute aborts
+// without writing anything.
+func (kvs *KVStore) GetOrPut(key string, value interface{}, compute func() (interface{}, error)) (err error) {
+	defer func() { kvs.logOp("GetOrPut", key, err) }()
+	return kvs.update(func(tx *bolt.Tx) error {
+		v, err := kvs.lookup(tx, key)
+		if err == ErrNotFound || err == errExpired {
+			computed, err := compute()
+			if err != nil {
+				return err
+			}
+			if v, err = kvs.encode(key, computed); err != nil {
+				return err
+			}
+			if err := kvs.put(tx, key, v); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		}
+		if value == nil {
+			return nil
+		}
+		return kvs.decode(key, v, value)
+	})
+}
+
+// Decode the entry at key into value, call fn to change value in place,
+// then encode value and store it back, all in one transaction, so no
+// other write can come between the read and the write. value must be
+// pointer-typed. Returns ErrNotFound if key is missing or expired; use
+// ModifyOrCreate to start from a zero value instead. An error from fn
+// aborts without writing anything. An existing TTL on key is kept.
+func (kvs *KVStore) Modify(key string, value interface{}, fn func() error) (err error) {
+	defer func() { kvs.logOp("Modify", key, err) }()
+	return kvs.modify(key, value, fn, false)
+}
+
+// Like Modify, but a missing or expired key starts value off as its
+// type's zero value, so fn builds the entry from scratch.
+func (kvs *KVStore) ModifyOrCreate(key string, value interface{}, fn func() error) (err error) {
+	defer func() { kvs.logOp("ModifyOrCreate", key, err) }()
+	return kvs.modify(key, value, fn, true)
+}
+
+func (kvs *KVStore) modify(key string, value interface{}, fn func() error, create bool) error {
+	dst := reflect.ValueOf(value)
+	if dst.Kind() != reflect.Ptr || dst.IsNil() {
+		return ErrBadValue
+	}
+	return kvs.update(func(tx *bolt.Tx) error {
+		// Gob leaves out zero fields, so decoding over a value left from
+		// an earlier call could keep some of it.
+		dst.Elem().Set(reflect.Zero(dst.Elem().Type()))
+		v, err := kvs.lookup(tx, key)
+		live := err == nil
+		switch {
+		case live:
+			if err := kvs.decode(key, v, value); err != nil {
+				return err
+			}
+		case err == ErrNotFound || err == errExpired:
+			if !create {
+				return ErrNotFound
+			}
+		default:
+			return err
+		}
+		if err := fn(); err != nil {
+			return err
+		}
+		data, err := kvs.encode(key, value)
+		if err != nil {
+			return err
+		}
+		if live {
+			return kvs.write(tx, key, data)
+		}
+		return kvs.put(tx, key, data)
+	})
+}
+
+// Put value at key and decode whatever was there before into prev, in one
+// transaction. Reports whether there was a previous value; if not, prev is
+// left untouched. If the previous value can't be decoded into prev,
+// nothing is written.
+func (kvs *KVStore) Swap(key string, value, prev interface{}) (_ bool, err error) {
+	defer func() { kvs.logOp("Swap", key, err) }()
+	data, err := kvs.encode(key, value)
+	if err != nil {
+		return false, err
+	}
+	found := false
+	err = kvs.update(func(tx *bolt.Tx) error {
+		found = false
+		switch err := kvs.get(tx, key, prev); err {
+		case nil:
+			found = true
+		case ErrNotFound, errExpired:
+		default:
+			return err
+		}
+		return kvs.put(tx, key, data)
+	})
+	return found, err
+}
+
+// Put value at key only if key is missing or expired, in one transaction.
+// Reports whether it was written; false means a live entry was already
+// there and was left alone.
+func (kvs *KVStore) PutIfAbsent(key string, value interface{}) (_ bool, err error) {
+	defer func() { kvs.logOp("PutIfAbsent", key, err) }()
+	data, err := kvs.encode(key, value)
+	if err != nil {
+		return false, err
+	}
+	written := false
+	err = kvs.update(func(tx *bolt.Tx) error {
+		written = false
+		if kvs.has(tx, key) {
+			return nil
+		}
+		written = true
+		return kvs.put(tx, key, data)
+	})
+	return written, err
+}
+
+// Like Put, but also reports whether key was created rather than
+// overwritten, checked in the same transaction as the write. A key that
+// had expired counts as created.
+func (kvs *KVStore) PutReport(key string, value interface{}) (created bool, err error) {
+	defer func() { kvs.logOp("PutReport", key, err) }()
+	data, err := kvs.encode(key, value)
+	if err != nil {
+		return false, err
+	}
+	err = kvs.update(func(tx *bolt.Tx) error {
+		created = !kvs.has(tx, key)
+		return kvs.put(tx, key, data)
+	})
+	if err != nil {
+		return false, err
+	}
+	return created, nil
+}
+
+// Like Put, but also reports whether key held an entry that had expired
+// and not yet been swept, which the write replaced, checked in the same
+// transaction. Such an entry is logically absent, so Put treats it as
+// missing either way: the old value, its expiry and any index entries are
+// all replaced, and no stale TTL carries over.
+func (kvs *KVStore) PutReportExpired(key string, value interface{}) (expired bool, err error) {
+	defer func() { kvs.logOp("PutReportExpired", key, err) }()
+	data, err := kvs.encode(key, value)
+	if err != nil {
+		return false, err
+	}
+	err = kvs.update(func(tx *bolt.Tx) error {
+		_, err := kvs.lookup(tx, key)
+		expired = err == errExpired
+		return kvs.put(tx, key, data)
+	})
+	if err != nil {
+		return false, err
+	}
+	return expired, nil
+}
+
+// Decode the entry at key into value and delete it, in one transaction,
+// so that of several callers taking the same key only one gets it. Returns
+// ErrNotFound if key is missing or expired. If the entry can't be decoded
+// into value it is left in place.
+func (kvs *KVStore) Take(key string, value interface{}) (err error) {
+	defer func() { kvs.logOp("Take", key, err) }()
+	found := false
+	err = kvs.update(func(tx *bolt.Tx) error {
+		found = false
+		switch err := kvs.get(tx, key, value); err {
+		case nil:
+			found = true
+		case errExpired:
+			// Not ours to take, but delete it while we're here.
+		default:
+			return err
+		}
+		_, err := kvs.delete(tx, key)
+		return err
+	})
+	if err == nil && !found {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Move the entry at oldKey to newKey in one transaction, replacing
+// anything already at newKey. The bytes are moved as stored, without
+// decoding, and any TTL moves with them. Returns ErrNotFound if oldKey is
+// missing or expired.
+func (kvs *KVStore) Rename(oldKey, newKey string) (err error) {
+	defer func() { kvs.logOp("Rename", oldKey, err) }()
+	return kvs.rename(oldKey, newKey, true)
+}
+
+// Like Rename, but fails with ErrKeyExists instead of replacing a live
+// entry at newKey.
+func (kvs *KVStore) RenameNoReplace(oldKey, newKey string) (err error) {
+	defer func() { kvs.logOp("RenameNoReplace", oldKey, err) }()
+	return kvs.rename(oldKey, newKey, false)
+}
+
+func (kvs *KVStore) rename(oldKey, newKey string, replace bool) error {
+	return kvs.update(func(tx *bolt.Tx) error {
+		v, err := kvs.lookup(tx, oldKey)
+		if err == errExpired {
+			return ErrNotFound
+		} else if err != nil {
+			return err
+		}
+		if oldKey == newKey {
+			return nil
+		}
+		if !replace && kvs.has(tx, newKey) {
+			return ErrKeyExists
+		}
+		data := append([]byte(nil), v...)
+		expiry := tx.Bucket(kvs.expiryBucket)
+		expiresAt := append([]byte(nil), expiry.Get([]byte(oldKey))...)
+		if _, err := kvs.delete(tx, oldKey); err != nil {
+			return err
+		}
+		if err := kvs.write(tx, newKey, data); err != nil {
+			return err
+		}
+		return kvs.setExpiry(tx, newKey, expiresAt)
+	})
+}
@@ -0,0 +1,364 @@
+package kvs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var ErrEmptyPrefix = errors.New("kvs: empty prefix")
+
+// MissingKeysError lists the keys a batch operation couldn't find.
+// It matches ErrNotFound with errors.Is.
+type MissingKeysError struct {
+	Keys []string
+}
+
+func (e *MissingKeysError) Error() string {
+	return fmt.Sprintf("kvs: %d keys not found", len(e.Keys))
+}
+
+func (e *MissingKeysError) Unwrap() error {
+	return ErrNotFound
+}
+
+// Puts several entries into the Key-Value Store in one transaction.
+// Either every entry is written or none are. Every value is encoded
+// before anything is written, so a nil value fails the whole batch with
+// ErrBadValue.
+func (kvs *KVStore) PutAll(entries map[string]interface{}) (err error) {
+	defer func() {
+		for key := range entries {
+			kvs.logOp("PutAll", key, err)
+		}
+	}()
+	encoded, err := kvs.encodeAll(entries)
+	if err != nil {
+		return err
+	}
+	return kvs.update(func(tx *bolt.Tx) error {
+		return kvs.putAll(tx, encoded)
+	})
+}
+
+// Encode every value in entries, by key.
+func (kvs *KVStore) encodeAll(entries map[string]interface{}) (map[string][]byte, error) {
+	encoded := make(map[string][]byte, len(entries))
+	for key, value := range entries {
+		data, err := kvs.encode(key, value)
+		if err != nil {
+			return nil, err
+		}
+		encoded[key] = data
+	}
+	return encoded, nil
+}
+
+// Put every encoded entry within tx.
+func (kvs *KVStore) putAll(tx *bolt.Tx, encoded map[string][]byte) error {
+	for key, data := range encoded {
+		if err := kvs.put(tx, key, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Like Put, but concurrent calls from many goroutines are coalesced into
+// shared transactions with Bolt's Batch, so they share one fsync. Each call
+// still returns only once its write is committed. Throughput improves
+// under concurrent load; a lone caller waits a few milliseconds longer
+// than with Put. The value is encoded once up front, since Bolt may retry
+// the write.
+func (kvs *KVStore) PutBatch(key string, value interface{}) (err error) {
+	defer func() { kvs.logOp("PutBatch", key, err) }()
+	data, err := kvs.encode(key, value)
+	if err != nil {
+		return err
+	}
+	return kvs.batch(func(tx *bolt.Tx) error {
+		return kvs.put(tx, key, data)
+	})
+}
+
+// Load many entries at once: fn is called with a put function that adds
+// an entry, and everything it puts is written in a single transaction once
+// fn returns nil. An error from fn, or from put, writes nothing. Pages are
+// packed full rather than leaving room for later inserts, which suits
+// loading keys in sorted order; put them in order for the best speed and
+// the smallest file. The whole load is held in memory until it commits.
+func (kvs *KVStore) BulkLoad(fn func(put func(key string, value interface{}) error) error) (err error) {
+	defer func() { kvs.logOp("BulkLoad", "", err) }()
+	return kvs.update(func(tx *bolt.Tx) error {
+		tx.Bucket(kvs.bucket).FillPercent = 1.0
+		return fn(func(key string, value interface{}) error {
+			data, err := kvs.encode(key, value)
+			if err != nil {
+				return err
+			}
+			return kvs.put(tx, key, data)
+		})
+	})
+}
+
+// Delete several keys from the Key-Value Store in one transaction.
+// Keys that are present are always deleted. If any keys were missing, a
+// *MissingKeysError listing them is returned after the deletes commit;
+// callers that don't care can check for it with errors.Is(err, ErrNotFound)
+// and carry on. Use DeleteAllStrict to delete nothing unless every key is
+// there.
+func (kvs *KVStore) DeleteAll(keys []string) error {
+	return kvs.deleteAll("DeleteAll", keys, false)
+}
+
+// Like DeleteAll, but all or nothing: if any of keys is missing, the
+// transaction is rolled back, nothing is deleted, and the
+// *MissingKeysError lists every key that was missing.
+func (kvs *KVStore) DeleteAllStrict(keys []string) error {
+	return kvs.deleteAll("DeleteAllStrict", keys, true)
+}
+
+func (kvs *KVStore) deleteAll(op string, keys []string, strict bool) error {
+	var missing []string
+	err := kvs.update(func(tx *bolt.Tx) error {
+		missing = nil
+		for _, key := range keys {
+			if found, err := kvs.delete(tx, key); err != nil {
+				return err
+			} else if !found {
+				missing = append(missing, key)
+			}
+		}
+		if strict && len(missing) > 0 {
+			return &MissingKeysError{Keys: missing}
+		}
+		return nil
+	})
+	if kvs.opts.logger != nil {
+		gone := make(map[string]bool, len(missing))
+		for _, key := range missing {
+			gone[key] = true
+		}
+		for _, key := range keys {
+			keyErr := err
+			if gone[key] && (keyErr == nil || strict) {
+				keyErr = ErrNotFound
+			}
+			kvs.logOp(op, key, keyErr)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return &MissingKeysError{Keys: missing}
+	}
+	return nil
+}
+
+// Delete keys in transactions of chunkSize keys each, so a garbage
+// collector removing millions of keys pays for one fsync per chunk rather
+// than per key, without building one huge transaction. A chunkSize below 1
+// puts every key in one transaction. Missing and expired keys are skipped
+// silently; the count returned is of live entries removed. If a chunk
+// fails, the chunks before it stay deleted and their count is returned
+// with the error.
+func (kvs *KVStore) DeleteAllBatched(keys []string, chunkSize int) (_ int, err error) {
+	defer func() { kvs.logOp("DeleteAllBatched", "", err) }()
+	if chunkSize < 1 {
+		chunkSize = len(keys)
+	}
+	n := 0
+	for len(keys) > 0 {
+		chunk := keys
+		if len(chunk) > chunkSize {
+			chunk = chunk[:chunkSize]
+		}
+		deleted := 0
+		err = kvs.update(func(tx *bolt.Tx) error {
+			deleted = 0
+			for _, key := range chunk {
+				if live, err := kvs.delete(tx, key); err != nil {
+					return err
+				} else if live {
+					deleted++
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return n, err
+		}
+		n += deleted
+		keys = keys[len(chunk):]
+	}
+	return n, nil
+}
+
+// Delete every key starting with prefix in one transaction, returning how
+// many were removed. An empty prefix would wipe the store, so it is
+// rejected with ErrEmptyPrefix.
+func (kvs *KVStore) DeleteWithPrefix(prefix string) (_ int, err error) {
+	defer func() { kvs.logOp("DeleteWithPrefix", prefix, err) }()
+	if prefix == "" {
+		return 0, ErrEmptyPrefix
+	}
+	n := 0
+	err = kvs.update(func(tx *bolt.Tx) error {
+		n = 0
+		cursor := tx.Bucket(kvs.bucket).Cursor()
+		p := []byte(prefix)
+		// Deleting moves the cursor, so seek afresh for each key rather
+		// than stepping with Next.
+		for k, _ := cursor.Seek(p); k != nil && bytes.HasPrefix(k, p); k, _ = cursor.Seek(p) {
+			if live, err := kvs.delete(tx, string(k)); err != nil {
+				return err
+			} else if live {
+				n++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Call fn for every entry in key order within one write transaction,
+// deleting those for which fn returns true, and return how many were
+// deleted. raw is only valid until fn returns. Expired entries are
+// skipped. An error from fn rolls back every delete made so far and is
+// returned with a count of 0. Other writers wait until the pass is done.
+func (kvs *KVStore) ForEachWithDelete(fn func(key string, raw []byte) (delete bool, err error)) (_ int, err error) {
+	defer func() { kvs.logOp("ForEachWithDelete", "", err) }()
+	n := 0
+	err = kvs.update(func(tx *bolt.Tx) error {
+		n = 0
+		cursor := tx.Bucket(kvs.bucket).Cursor()
+		expiry, now := tx.Bucket(kvs.expiryBucket), time.Now()
+		for k, v := cursor.First(); k != nil; {
+			if expired(expiry, k, now) {
+				k, v = cursor.Next()
+				continue
+			}
+			key := string(k)
+			del, err := fn(key, v)
+			if err != nil {
+				return err
+			}
+			if !del {
+				k, v = cursor.Next()
+				continue
+			}
+			if _, err := kvs.delete(tx, key); err != nil {
+				return err
+			}
+			n++
+			// Deleting moves the cursor; key is gone, so seeking to it
+			// lands on the entry after.
+			k, v = cursor.Seek([]byte(key))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Report which of keys have live entries, checked in one read transaction
+// without decoding any values. Every key is in the result, with false for
+// missing and expired ones.
+func (kvs *KVStore) HasMulti(keys []string) (map[string]bool, error) {
+	found := make(map[string]bool, len(keys))
+	err := kvs.view(func(tx *bolt.Tx) error {
+		for _, key := range keys {
+			found[key] = kvs.has(tx, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// Decode several entries in one read transaction: keys[i] into values[i].
+// Keys that can't be read, whether missing or failing to decode, don't
+// fail the call; their errors are returned in the map, which is empty when
+// everything was found. The error is for the transaction as a whole, or
+// ErrBadValue if keys and values differ in length.
+func (kvs *KVStore) GetMulti(keys []string, values []interface{}) (map[string]error, error) {
+	if len(keys) != len(values) {
+		return nil, ErrBadValue
+	}
+	var failed map[string]error
+	var stale []string
+	err := kvs.view(func(tx *bolt.Tx) error {
+		failed, stale = map[string]error{}, nil
+		for i, key := range keys {
+			v, err := kvs.lookup(tx, key)
+			if err == errExpired {
+				stale = append(stale, key)
+				err = ErrNotFound
+			}
+			if err == nil && values[i] != nil {
+				err = kvs.decode(key, v, values[i])
+			}
+			if err != nil {
+				failed[key] = err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range stale {
+		kvs.purge(key)
+	}
+	return failed, nil
+}
+
+// Delete every entry in the store by dropping and recreating its bucket,
+// which is much faster than deleting keys one at a time. Watchers aren't
+// told about the individual keys; streams from StreamChanges and the
+// WithChangeLog log are, so replicas delete them too. Clearing an empty
+// store is fine.
+func (kvs *KVStore) Clear() (err error) {
+	defer func() { kvs.logOp("Clear", "", err) }()
+	return kvs.update(func(tx *bolt.Tx) error {
+		kvs.cache.invalidateAll(tx)
+		if kvs.watch.streaming() || kvs.opts.changeLog {
+			var keys []string
+			tx.Bucket(kvs.bucket).ForEach(func(k, _ []byte) error {
+				keys = append(keys, string(k))
+				return nil
+			})
+			for _, key := range keys {
+				if err := kvs.logChange(tx, key); err != nil {
+					return err
+				}
+			}
+			if kvs.watch.streaming() {
+				tx.OnCommit(func() { kvs.watch.notifyStreams(keys) })
+			}
+		}
+		if err := kvs.clearIndexes(tx); err != nil {
+			return err
+		}
+		for _, name := range [][]byte{kvs.bucket, kvs.expiryBucket} {
+			if err := tx.DeleteBucket(name); err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,41 @@
+package kvs
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDeleteAllStrictIsAllOrNothing(t *testing.T) {
+	kvs := openTest(t)
+	if err := kvs.PutAll(map[string]interface{}{"a": 1, "b": 2}); err != nil {
+		t.Fatal(err)
+	}
+	err := kvs.DeleteAllStrict([]string{"a", "missing", "b"})
+	var missing *MissingKeysError
+	if !errors.As(err, &missing) || !reflect.DeepEqual(missing.Keys, []string{"missing"}) {
+		t.Fatalf("DeleteAllStrict: %v", err)
+	}
+	if keys, _ := kvs.Keys(); !reflect.DeepEqual(keys, []string{"a", "b"}) {
+		t.Fatalf("after a failed DeleteAllStrict the store holds %q", keys)
+	}
+	if err := kvs.DeleteAllStrict([]string{"a", "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ := kvs.Keys(); len(keys) != 0 {
+		t.Fatalf("after DeleteAllStrict the store holds %q", keys)
+	}
+}
+
+func TestDeleteAllDeletesWhatExists(t *testing.T) {
+	kvs := openTest(t)
+	if err := kvs.PutAll(map[string]interface{}{"a": 1, "b": 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := kvs.DeleteAll([]string{"a", "missing"}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("DeleteAll: %v", err)
+	}
+	if keys, _ := kvs.Keys(); !reflect.DeepEqual(keys, []string{"b"}) {
+		t.Fatalf("after DeleteAll the store holds %q", keys)
+	}
+}
@@ -0,0 +1,62 @@
+package kvs
+
+import "encoding/binary"
+
+// Bolt keys are bytes, and a string key is just its bytes, so these are
+// the same entries the string methods see: PutBytes([]byte("a"), v) and
+// Put("a", v) write the same key. Binary keys such as hashes or
+// big-endian integers can be used as they are.
+
+// Like Put, with a binary key.
+func (kvs *KVStore) PutBytes(key []byte, value interface{}) error {
+	return kvs.Put(string(key), value)
+}
+
+// Like Get, with a binary key.
+func (kvs *KVStore) GetBytes(key []byte, value interface{}) error {
+	return kvs.Get(string(key), value)
+}
+
+// Like Delete, with a binary key.
+func (kvs *KVStore) DeleteBytes(key []byte) error {
+	return kvs.Delete(string(key))
+}
+
+// Integer keys written as decimal strings sort wrongly, "10" before "2".
+// Uint64Key instead encodes n as 8 big-endian bytes, which Bolt's byte
+// order sorts numerically, so Range, First, Last and the rest walk such
+// keys in numeric order. Pass Uint64Key values as Range bounds, and turn
+// keys that come back into numbers with ParseUint64Key. Mixing these keys
+// with ordinary string keys in one store works but interleaves them in
+// byte order, so a Namespace of their own is usually tidier.
+
+// Return the key that PutUint64 and GetUint64 use for n.
+func Uint64Key(n uint64) string {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], n)
+	return string(b[:])
+}
+
+// Return the number encoded in a key made by Uint64Key. Reports false for
+// keys that aren't 8 bytes long.
+func ParseUint64Key(key string) (uint64, bool) {
+	if len(key) != 8 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64([]byte(key)), true
+}
+
+// Like Put, with a numerically ordered integer key.
+func (kvs *KVStore) PutUint64(key uint64, value interface{}) error {
+	return kvs.Put(Uint64Key(key), value)
+}
+
+// Like Get, with a numerically ordered integer key.
+func (kvs *KVStore) GetUint64(key uint64, value interface{}) error {
+	return kvs.Get(Uint64Key(key), value)
+}
+
+// Like Delete, with a numerically ordered integer key.
+func (kvs *KVStore) DeleteUint64(key uint64) error {
+	return kvs.Delete(Uint64Key(key))
+}
@@ -0,0 +1,168 @@
+package kvs
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// CacheStats counts lookups served by the cache set up WithReadCache.
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+}
+
+// readCache is an LRU of stored bytes by key, in front of Get and GetRaw.
+// A nil *readCache is a disabled cache, so callers needn't check.
+//
+// Every invalidation bumps gen. A reader notes gen before its transaction
+// and only adds what it read if gen hasn't moved since, so a value read
+// just before a write commits is never cached after the write has
+// invalidated it.
+type readCache struct {
+	mu    sync.Mutex
+	max   int
+	lru   *list.List
+	items map[string]*list.Element
+	gen   uint64
+
+	hits, misses uint64
+}
+
+type cacheEntry struct {
+	key  string
+	data []byte
+	// When the entry expires; zero for never.
+	expires time.Time
+}
+
+func newReadCache(max int) *readCache {
+	if max <= 0 {
+		return nil
+	}
+	return &readCache{max: max, lru: list.New(), items: make(map[string]*list.Element)}
+}
+
+// Return the cached bytes for key, counting a hit or a miss. Entries past
+// their expiry count as misses, so the caller goes to the store and
+// purges them.
+func (c *readCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*cacheEntry)
+		if e.expires.IsZero() || time.Now().Before(e.expires) {
+			c.lru.MoveToFront(el)
+			atomic.AddUint64(&c.hits, 1)
+			return e.data, true
+		}
+		c.lru.Remove(el)
+		delete(c.items, key)
+	}
+	atomic.AddUint64(&c.misses, 1)
+	return nil, false
+}
+
+func (c *readCache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// Cache data for key, read by a reader that started at generation gen.
+// data must not be modified afterwards.
+func (c *readCache) add(gen uint64, key string, data []byte, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != c.gen {
+		return
+	}
+	if el, ok := c.items[key]; ok {
+		el.Value = &cacheEntry{key: key, data: data, expires: expires}
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.lru.PushFront(&cacheEntry{key: key, data: data, expires: expires})
+	if c.lru.Len() > c.max {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// Drop key, or everything if all is set.
+func (c *readCache) drop(key string, all bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	if all {
+		c.lru.Init()
+		c.items = make(map[string]*list.Element)
+	} else if el, ok := c.items[key]; ok {
+		c.lru.Remove(el)
+		delete(c.items, key)
+	}
+}
+
+// Forget key, which tx is changing: now, and again once tx commits, in
+// case a reader cached the old value in between.
+func (c *readCache) invalidate(tx *bolt.Tx, key string) {
+	if c == nil {
+		return
+	}
+	c.drop(key, false)
+	tx.OnCommit(func() { c.drop(key, false) })
+}
+
+// Forget everything, as invalidate does for one key.
+func (c *readCache) invalidateAll(tx *bolt.Tx) {
+	if c == nil {
+		return
+	}
+	c.drop("", true)
+	tx.OnCommit(func() { c.drop("", true) })
+}
+
+// Return the bytes stored at key, from the cache if possible, filling the
+// cache on a miss. Fails like lookup. The result must not be modified.
+func (kvs *KVStore) cached(key string) ([]byte, error) {
+	if kvs.closed() {
+		return nil, ErrClosed
+	}
+	if data, ok := kvs.cache.get(key); ok {
+		return data, nil
+	}
+	gen := kvs.cache.generation()
+	var data []byte
+	var expires time.Time
+	err := kvs.view(func(tx *bolt.Tx) error {
+		v, err := kvs.lookup(tx, key)
+		if err != nil {
+			return err
+		}
+		data = append([]byte(nil), v...)
+		expires, _ = expiresAt(tx.Bucket(kvs.expiryBucket), []byte(key))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	kvs.cache.add(gen, key, data, expires)
+	return data, nil
+}
+
+// Return how many Get and GetRaw calls the read cache has answered, and
+// how many it couldn't. Both are zero unless the store was opened
+// WithReadCache.
+func (kvs *KVStore) CacheStats() CacheStats {
+	if kvs.cache == nil {
+		return CacheStats{}
+	}
+	return CacheStats{
+		Hits:   atomic.LoadUint64(&kvs.cache.hits),
+		Misses: atomic.LoadUint64(&kvs.cache.misses),
+	}
+}
@@ -0,0 +1,88 @@
+package kvs
+
+import (
+	"encoding/binary"
+	"errors"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var ErrNoChangeLog = errors.New("kvs: store wasn't opened WithChangeLog")
+
+// With WithChangeLog, two buckets nested in the meta bucket track the
+// order of writes: changeLogBucket maps an 8-byte big-endian sequence
+// number to the key written then, and changeSeqBucket maps each key back
+// to its latest number, so a key rewritten many times only appears once,
+// at its last write.
+const (
+	changeLogBucket = "changes"
+	changeSeqBucket = "changes:seq"
+)
+
+// Note within tx that key has just been written or deleted.
+func (kvs *KVStore) logChange(tx *bolt.Tx, key string) error {
+	if !kvs.opts.changeLog {
+		return nil
+	}
+	meta := tx.Bucket(kvs.metaBucket)
+	log, err := meta.CreateBucketIfNotExists([]byte(changeLogBucket))
+	if err != nil {
+		return err
+	}
+	seqs, err := meta.CreateBucketIfNotExists([]byte(changeSeqBucket))
+	if err != nil {
+		return err
+	}
+	if prev := seqs.Get([]byte(key)); prev != nil {
+		if err := log.Delete(prev); err != nil {
+			return err
+		}
+	}
+	n, err := log.NextSequence()
+	if err != nil {
+		return err
+	}
+	seq := make([]byte, 8)
+	binary.BigEndian.PutUint64(seq, n)
+	if err := log.Put(seq, []byte(key)); err != nil {
+		return err
+	}
+	return seqs.Put([]byte(key), seq)
+}
+
+// Return the keys written or deleted after sequence number seq, oldest
+// change first, each once however often it changed, along with the number
+// to pass next time. Start from 0. A consumer persists newSeq and polls;
+// a key it gets back that Get reports as ErrNotFound was deleted or has
+// expired. Deletes by the sweeper and by lazy expiry are included, as is
+// every key Clear drops. Returns ErrNoChangeLog unless the store was
+// opened WithChangeLog.
+func (kvs *KVStore) Since(seq uint64) (keys []string, newSeq uint64, err error) {
+	if !kvs.opts.changeLog {
+		return nil, 0, ErrNoChangeLog
+	}
+	keys, newSeq = []string{}, seq
+	err = kvs.view(func(tx *bolt.Tx) error {
+		keys, newSeq = keys[:0], seq
+		meta := tx.Bucket(kvs.metaBucket)
+		if meta == nil {
+			return nil
+		}
+		log := meta.Bucket([]byte(changeLogBucket))
+		if log == nil {
+			return nil
+		}
+		start := make([]byte, 8)
+		binary.BigEndian.PutUint64(start, seq+1)
+		cursor := log.Cursor()
+		for k, v := cursor.Seek(start); k != nil; k, v = cursor.Next() {
+			keys = append(keys, string(v))
+			newSeq = binary.BigEndian.Uint64(k)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return keys, newSeq, nil
+}
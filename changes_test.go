@@ -0,0 +1,31 @@
+package kvs
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestSinceReportsClear(t *testing.T) {
+	kvs := openTest(t, WithChangeLog())
+	for _, key := range []string{"a", "b"} {
+		if err := kvs.Put(key, "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, seq, err := kvs.Since(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kvs.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	keys, newSeq, err := kvs.Since(seq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(keys)
+	if !reflect.DeepEqual(keys, []string{"a", "b"}) || newSeq <= seq {
+		t.Fatalf("Since(%d) after Clear = %q, %d", seq, keys, newSeq)
+	}
+}
@@ -0,0 +1,43 @@
+package kvs
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+var ErrChecksumMismatch = errors.New("kvs: value checksum mismatch")
+
+// Marks a checksummed value. Like compressedFlag, it can't start gob or
+// JSON output, so values written without a checksum are still recognised.
+const checksumFlag = 0x01
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// checksummingCodec frames the output of another Codec as checksumFlag, a
+// big-endian CRC-32C of the encoded bytes, then the bytes themselves.
+type checksummingCodec struct {
+	Codec
+}
+
+func (c checksummingCodec) Marshal(value interface{}) ([]byte, error) {
+	data, err := c.Codec.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	framed := make([]byte, 5+len(data))
+	framed[0] = checksumFlag
+	binary.BigEndian.PutUint32(framed[1:5], crc32.Checksum(data, crcTable))
+	copy(framed[5:], data)
+	return framed, nil
+}
+
+func (c checksummingCodec) Unmarshal(data []byte, value interface{}) error {
+	if len(data) > 0 && data[0] == checksumFlag {
+		if len(data) < 5 || binary.BigEndian.Uint32(data[1:5]) != crc32.Checksum(data[5:], crcTable) {
+			return ErrChecksumMismatch
+		}
+		data = data[5:]
+	}
+	return c.Codec.Unmarshal(data, value)
+}
@@ -0,0 +1,64 @@
+package kvs
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+)
+
+var ErrDecrypt = errors.New("kvs: cannot decrypt value")
+
+// encryptingCodec seals the output of another Codec with AES-GCM. Each
+// value is stored as a random nonce followed by the ciphertext. The key
+// isn't bound to the value, so raw bytes can be moved between keys.
+type encryptingCodec struct {
+	Codec
+	aead cipher.AEAD
+}
+
+func newEncryptingCodec(c Codec, key []byte) (Codec, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return encryptingCodec{Codec: c, aead: aead}, nil
+}
+
+func (c encryptingCodec) Marshal(value interface{}) ([]byte, error) {
+	data, err := c.Codec.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	size := c.aead.NonceSize()
+	nonce := make([]byte, size, size+len(data)+c.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(nonce, nonce, data, nil), nil
+}
+
+func (c encryptingCodec) Unmarshal(data []byte, value interface{}) error {
+	plain, err := c.open(data)
+	if err != nil {
+		return err
+	}
+	return c.Codec.Unmarshal(plain, value)
+}
+
+// Decrypt data back into the inner Codec's output.
+func (c encryptingCodec) open(data []byte) ([]byte, error) {
+	size := c.aead.NonceSize()
+	if len(data) < size {
+		return nil, ErrDecrypt
+	}
+	plain, err := c.aead.Open(nil, data[:size], data[size:], nil)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plain, nil
+}
@@ -0,0 +1,63 @@
+package kvs
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestClosedStoreIgnoresReadCache(t *testing.T) {
+	kvs := openTest(t, WithReadCache(10))
+	if err := kvs.Put("k", 1); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := kvs.Get("k", &n); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kvs.GetRaw("k"); err != nil {
+		t.Fatal(err)
+	}
+	kvs.Close()
+	if err := kvs.Get("k", &n); err != ErrClosed {
+		t.Errorf("Get after Close: %v", err)
+	}
+	if _, err := kvs.GetRaw("k"); err != ErrClosed {
+		t.Errorf("GetRaw after Close: %v", err)
+	}
+	if _, err := kvs.GetWithRaw("k", &n); err != ErrClosed {
+		t.Errorf("GetWithRaw after Close: %v", err)
+	}
+}
+
+func TestCallsRacingCloseFailWithErrClosed(t *testing.T) {
+	for round := 0; round < 20; round++ {
+		kvs := openTest(t)
+		var wg sync.WaitGroup
+		start := make(chan struct{})
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				<-start
+				for i := 0; i < 50; i++ {
+					var err error
+					if i%2 == 0 {
+						err = kvs.PutBatch(fmt.Sprint(g, i), i)
+					} else {
+						err = kvs.Put(fmt.Sprint(g, i), i)
+					}
+					if err == ErrClosed {
+						return
+					} else if err != nil {
+						t.Errorf("write racing Close: %v", err)
+						return
+					}
+				}
+			}(g)
+		}
+		close(start)
+		kvs.Close()
+		wg.Wait()
+	}
+}
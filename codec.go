@@ -0,0 +1,239 @@
+package kvs
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// A Codec converts values to and from the bytes kept in the store.
+type Codec interface {
+	Marshal(value interface{}) ([]byte, error)
+	// Unmarshal decodes data into value, which must be pointer-typed.
+	Unmarshal(data []byte, value interface{}) error
+}
+
+var ErrDecode = errors.New("kvs: cannot decode value")
+
+// An EncodeError reports a value the Codec couldn't encode, naming its
+// type. Err is the Codec's own error, or nil for kinds such as channels
+// and funcs that are rejected up front. It matches ErrBadValue with
+// errors.Is.
+type EncodeError struct {
+	Type reflect.Type
+	Err  error
+}
+
+func (e *EncodeError) Error() string {
+	msg := "kvs: cannot encode value of type " + e.Type.String()
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *EncodeError) Unwrap() error {
+	return e.Err
+}
+
+func (e *EncodeError) Is(target error) bool {
+	return target == ErrBadValue
+}
+
+// A DecodeError reports an entry that is present but couldn't be decoded
+// into the value given, usually because it was stored as a different type.
+// Err is the Codec's error. It matches ErrDecode with errors.Is.
+type DecodeError struct {
+	Key  string
+	Type reflect.Type
+	Err  error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("kvs: cannot decode %q into %v: %v", e.Key, e.Type, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+func (e *DecodeError) Is(target error) bool {
+	return target == ErrDecode
+}
+
+// Report whether no Codec could encode a value of type t: channels and
+// funcs, directly or behind pointers.
+func unencodable(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Chan || t.Kind() == reflect.Func
+}
+
+// GobCodec encodes values with encoding/gob. It is the default.
+type GobCodec struct{}
+
+// Buffers reused across GobCodec.Marshal calls. Encoders can't be reused
+// the same way: a gob.Encoder only sends each type's description once, so
+// values after the first would be stored without it and couldn't be
+// decoded on their own.
+var gobBuffers = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// Largest buffer gobBuffers keeps, so one big value doesn't pin its
+// buffer's memory for as long as the pool holds on to it.
+const maxPooledGobBuffer = 64 << 10
+
+func (GobCodec) Marshal(value interface{}) ([]byte, error) {
+	buf := gobBuffers.Get().(*bytes.Buffer)
+	defer func() {
+		if buf.Cap() <= maxPooledGobBuffer {
+			gobBuffers.Put(buf)
+		}
+	}()
+	buf.Reset()
+	if err := gob.NewEncoder(buf).Encode(value); err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), buf.Bytes()...), nil
+}
+
+func (GobCodec) Unmarshal(data []byte, value interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(value)
+}
+
+// Register concrete types with gob, so values of those types can be stored
+// in and read back from interfaces: interface-typed fields, or whole
+// entries as GetValue reads them. Call it, usually from init, before any
+// Put or Get of such values; until a type is registered gob refuses to
+// encode it behind an interface and can't decode it either. Each value is
+// passed to gob.Register, which panics on the same conflicts.
+func Register(values ...interface{}) {
+	for _, value := range values {
+		gob.Register(value)
+	}
+}
+
+// JSONCodec encodes values with encoding/json, so the file can be read
+// by programs not written in Go.
+//
+// A value Put behind a pointer to an interface{}, the same convention gob
+// needs for GetValue, is wrapped with a type tag when its dynamic type is
+// registered with RegisterJSON, so it can be read back into an
+// interface{} as the type it was stored as:
+//
+//	{"$type":"user","$value":{"name":"ann"}}
+//
+// Decoding such an entry into a concrete type, such as a *User or a map,
+// just unwraps $value, so tags only matter to interface{} destinations,
+// and other programs writing entries the Go side reads into interface{}
+// need to add them. Everything else, including every value Put directly
+// and every value of a type that isn't registered, is stored as plain
+// JSON and can be read and written by other programs as it is; objects
+// whose "$type" isn't a registered name decode as ordinary objects.
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(value interface{}) ([]byte, error) {
+	// A pointer to an interface, as gob needs for GetValue, asks for the
+	// dynamic type to be kept, which JSON can only do with a tag.
+	if p, ok := value.(*interface{}); ok && p != nil {
+		value = *p
+		if name, ok := jsonTypeName(reflect.TypeOf(value)); ok {
+			return json.Marshal(jsonTagged{Type: name, Value: value})
+		}
+	}
+	return json.Marshal(value)
+}
+
+func (JSONCodec) Unmarshal(data []byte, value interface{}) error {
+	if bytes.Contains(data, []byte(`"$type"`)) {
+		var tagged struct {
+			Type  string          `json:"$type"`
+			Value json.RawMessage `json:"$value"`
+		}
+		// Objects that only look tagged, with a name nobody registered,
+		// are plain JSON like any other.
+		if json.Unmarshal(data, &tagged) == nil && tagged.Value != nil {
+			if t, ok := jsonType(tagged.Type); ok {
+				p, ok := value.(*interface{})
+				if !ok {
+					return json.Unmarshal(tagged.Value, value)
+				}
+				v := reflect.New(t)
+				if err := json.Unmarshal(tagged.Value, v.Interface()); err != nil {
+					return err
+				}
+				*p = v.Elem().Interface()
+				return nil
+			}
+		}
+	}
+	return json.Unmarshal(data, value)
+}
+
+// A value as JSONCodec stores it with a type tag.
+type jsonTagged struct {
+	Type  string      `json:"$type"`
+	Value interface{} `json:"$value"`
+}
+
+// Types registered with RegisterJSON, both ways round.
+var jsonTypes struct {
+	sync.RWMutex
+	byName map[string]reflect.Type
+	names  map[reflect.Type]string
+}
+
+// Register the type of value under name with JSONCodec, which from then
+// on stores values of that type, or pointers to it, Put behind a
+// *interface{} with name as their type tag, and decodes entries carrying the tag into interface{} as that
+// type. name is what other programs see in the file, so it should be
+// short and stable; it is often the type's name in lower case. Call it,
+// usually from init, before any Put or Get of such values. Registering a
+// name or type twice panics, unless it is the same pair.
+func RegisterJSON(name string, value interface{}) {
+	t := reflect.TypeOf(value)
+	jsonTypes.Lock()
+	defer jsonTypes.Unlock()
+	if other, ok := jsonTypes.byName[name]; ok && other != t {
+		panic(fmt.Sprintf("kvs: JSON type name %q registered for both %v and %v", name, other, t))
+	}
+	if other, ok := jsonTypes.names[t]; ok && other != name {
+		panic(fmt.Sprintf("kvs: JSON type %v registered as both %q and %q", t, other, name))
+	}
+	if jsonTypes.byName == nil {
+		jsonTypes.byName = make(map[string]reflect.Type)
+		jsonTypes.names = make(map[reflect.Type]string)
+	}
+	jsonTypes.byName[name] = t
+	jsonTypes.names[t] = name
+}
+
+// Return the tag JSONCodec gives values of type t, if any.
+func jsonTypeName(t reflect.Type) (string, bool) {
+	jsonTypes.RLock()
+	defer jsonTypes.RUnlock()
+	if len(jsonTypes.names) == 0 || t == nil {
+		return "", false
+	}
+	if name, ok := jsonTypes.names[t]; ok {
+		return name, true
+	}
+	if t.Kind() == reflect.Ptr {
+		name, ok := jsonTypes.names[t.Elem()]
+		return name, ok
+	}
+	return "", false
+}
+
+func jsonType(name string) (reflect.Type, bool) {
+	jsonTypes.RLock()
+	defer jsonTypes.RUnlock()
+	t, ok := jsonTypes.byName[name]
+	return t, ok
+}
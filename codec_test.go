@@ -0,0 +1,58 @@
+package kvs
+
+import (
+	"reflect"
+	"testing"
+)
+
+type jsonTestUser struct {
+	Name string `json:"name"`
+}
+
+func init() {
+	RegisterJSON("kvs-test-user", jsonTestUser{})
+}
+
+func TestJSONCodecTagsRegisteredTypes(t *testing.T) {
+	kvs := openTest(t, WithCodec(JSONCodec{}))
+	if err := kvs.Put("plain", jsonTestUser{Name: "ann"}); err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := kvs.GetRaw("plain")
+	if want := `{"name":"ann"}`; string(raw) != want {
+		t.Errorf("stored %s, want %s", raw, want)
+	}
+
+	var value interface{} = jsonTestUser{Name: "ann"}
+	if err := kvs.Put("u", &value); err != nil {
+		t.Fatal(err)
+	}
+	raw, _ = kvs.GetRaw("u")
+	if want := `{"$type":"kvs-test-user","$value":{"name":"ann"}}`; string(raw) != want {
+		t.Errorf("stored %s, want %s", raw, want)
+	}
+	v, err := kvs.GetValue("u")
+	if err != nil || !reflect.DeepEqual(v, jsonTestUser{Name: "ann"}) {
+		t.Errorf("GetValue = %#v, %v", v, err)
+	}
+	var u jsonTestUser
+	if err := kvs.Get("u", &u); err != nil || u.Name != "ann" {
+		t.Errorf("Get = %#v, %v", u, err)
+	}
+}
+
+func TestJSONCodecLeavesUnregisteredTagsAlone(t *testing.T) {
+	kvs := openTest(t, WithCodec(JSONCodec{}))
+	plain := map[string]interface{}{"$type": "x", "$value": 1.0}
+	if err := kvs.Put("m", plain); err != nil {
+		t.Fatal(err)
+	}
+	v, err := kvs.GetValue("m")
+	if err != nil || !reflect.DeepEqual(v, plain) {
+		t.Errorf("GetValue = %#v, %v", v, err)
+	}
+	var m map[string]interface{}
+	if err := kvs.Get("m", &m); err != nil || !reflect.DeepEqual(m, plain) {
+		t.Errorf("Get = %#v, %v", m, err)
+	}
+}
@@ -0,0 +1,191 @@
+package kvs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Entries written per transaction while compacting.
+const compactBatchSize = 10000
+
+// Copy the database into a new, defragmented file at destPath, which must
+// not exist yet. Every bucket in the file is copied, not just this store's,
+// so the result can replace the original outright.
+//
+// Bolt never shrinks its file, so this is how space freed by deletes is
+// given back. Expect to need about twice the current file size in disk
+// space while the copy runs. Reads see a consistent snapshot throughout,
+// but writes made during compaction won't be in the copy, so quiesce
+// writers first.
+func (kvs *KVStore) Compact(destPath string) error {
+	if err := kvs.lockDB(); err != nil {
+		return err
+	}
+	defer kvs.root.dbMu.RUnlock()
+	return kvs.compact(destPath)
+}
+
+// Compact, for a caller already holding off Reopen.
+func (kvs *KVStore) compact(destPath string) error {
+	if _, err := os.Stat(destPath); err == nil {
+		return &os.PathError{Op: "compact", Path: destPath, Err: os.ErrExist}
+	}
+	info, err := os.Stat(kvs.db.Path())
+	if err != nil {
+		return err
+	}
+	dst, err := bolt.Open(destPath, info.Mode().Perm(), nil)
+	if err != nil {
+		return err
+	}
+	err = kvs.db.View(func(src *bolt.Tx) error {
+		tx, err := dst.Begin(true)
+		if err != nil {
+			return err
+		}
+		c := &compactor{dst: dst, tx: tx}
+		err = src.ForEach(func(name []byte, b *bolt.Bucket) error {
+			return c.copy([][]byte{name}, b)
+		})
+		if err != nil {
+			c.tx.Rollback()
+			return err
+		}
+		return c.tx.Commit()
+	})
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(destPath)
+	}
+	return err
+}
+
+// Compact the newly opened database in place if more than the
+// WithAutoCompact ratio of its pages are free, reopening it with boltOpts
+// afterwards. On error kvs.db may be closed, or a different *bolt.DB.
+func (kvs *KVStore) autoCompact(boltOpts *bolt.Options) (err error) {
+	// Bolt only fills in the freelist stats when a write transaction
+	// ends, so start one and roll it back.
+	tx, err := kvs.db.Begin(true)
+	if err != nil {
+		return err
+	}
+	size := tx.Size()
+	tx.Rollback()
+	pages := size / int64(kvs.db.Info().PageSize)
+	stats := kvs.db.Stats()
+	free := int64(stats.FreePageN + stats.PendingPageN)
+	if pages == 0 || float64(free)/float64(pages) <= kvs.opts.autoCompact {
+		return nil
+	}
+	defer func() { kvs.logOp("AutoCompact", "", err) }()
+	path := kvs.db.Path()
+	// Compact wants a path that doesn't exist yet, in the same directory
+	// so the rename stays on one filesystem.
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".compact-")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	f.Close()
+	os.Remove(tmp)
+	if err := kvs.compact(tmp); err != nil {
+		return err
+	}
+	if err := kvs.db.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	db, err := bolt.Open(path, kvs.opts.fileMode, boltOpts)
+	if err == bolt.ErrTimeout {
+		return ErrLocked
+	} else if err != nil {
+		return err
+	}
+	kvs.db = db
+	return nil
+}
+
+// A compactor copies buckets into dst, committing every compactBatchSize
+// entries so no single transaction grows too large.
+type compactor struct {
+	dst *bolt.DB
+	tx  *bolt.Tx
+	n   int
+	// Bumped on every commit, invalidating buckets from the old tx.
+	gen int
+}
+
+func (c *compactor) copy(path [][]byte, src *bolt.Bucket) error {
+	var dst *bolt.Bucket
+	gen := -1
+	open := func() error {
+		if gen == c.gen {
+			return nil
+		}
+		b, err := c.bucket(path)
+		if err != nil {
+			return err
+		}
+		if err := b.SetSequence(src.Sequence()); err != nil {
+			return err
+		}
+		// Keys arrive in order, so pages can be packed full.
+		b.FillPercent = 1.0
+		dst, gen = b, c.gen
+		return nil
+	}
+	// Create the bucket even if it turns out to be empty.
+	if err := open(); err != nil {
+		return err
+	}
+	return src.ForEach(func(k, v []byte) error {
+		if v == nil {
+			return c.copy(append(path[:len(path):len(path)], k), src.Bucket(k))
+		}
+		if c.n >= compactBatchSize {
+			if err := c.commit(); err != nil {
+				return err
+			}
+		}
+		if err := open(); err != nil {
+			return err
+		}
+		c.n++
+		return dst.Put(k, v)
+	})
+}
+
+// Find or create the bucket at path in the current transaction.
+func (c *compactor) bucket(path [][]byte) (*bolt.Bucket, error) {
+	b, err := c.tx.CreateBucketIfNotExists(path[0])
+	for _, name := range path[1:] {
+		if err != nil {
+			break
+		}
+		b, err = b.CreateBucketIfNotExists(name)
+	}
+	return b, err
+}
+
+func (c *compactor) commit() error {
+	if err := c.tx.Commit(); err != nil {
+		return err
+	}
+	tx, err := c.dst.Begin(true)
+	if err != nil {
+		return err
+	}
+	c.tx, c.n = tx, 0
+	c.gen++
+	return nil
+}
@@ -0,0 +1,65 @@
+package kvs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAutoCompactShrinksFileOnOpen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "kvs.db")
+	kvs, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := make(map[string]interface{})
+	for i := 0; i < 2000; i++ {
+		entries[fmt.Sprint(i)] = make([]byte, 4000)
+	}
+	if err := kvs.PutAll(entries); err != nil {
+		t.Fatal(err)
+	}
+	for i := 10; i < 2000; i++ {
+		if err := kvs.Delete(fmt.Sprint(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	kvs.Close()
+	before, _ := os.Stat(path)
+
+	compacted := 0
+	logger := func(op, key string, err error) {
+		if op == "AutoCompact" {
+			compacted++
+			if err != nil {
+				t.Errorf("AutoCompact: %v", err)
+			}
+		}
+	}
+	kvs, err = Open(path, WithAutoCompact(0.5), WithLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, _ := os.Stat(path)
+	if compacted != 1 || after.Size() >= before.Size() {
+		t.Fatalf("compacted %d times, file %d bytes before, %d after", compacted, before.Size(), after.Size())
+	}
+	if n, err := kvs.Count(); err != nil || n != 10 {
+		t.Errorf("Count after compacting = %d, %v", n, err)
+	}
+	kvs.Close()
+	if files, _ := os.ReadDir(dir); len(files) != 1 {
+		t.Errorf("compacting left %d files behind", len(files)-1)
+	}
+
+	kvs, err = Open(path, WithAutoCompact(0.5), WithLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	kvs.Close()
+	if compacted != 1 {
+		t.Errorf("compacted a file that was already compact")
+	}
+}
@@ -0,0 +1,88 @@
+package kvs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Marks a compressed value. Neither gob nor JSON output ever starts with a
+// zero byte, so uncompressed values never carry it.
+const compressedFlag = 0x00
+
+// compressingCodec gzips the output of another Codec when it is at least
+// threshold bytes. Compressed values are compressedFlag followed by the
+// gzip stream; anything else is the inner Codec's output unchanged.
+type compressingCodec struct {
+	Codec
+	threshold int
+}
+
+func (c compressingCodec) Marshal(value interface{}) ([]byte, error) {
+	data, err := c.Codec.Marshal(value)
+	if err != nil || len(data) < c.threshold {
+		return data, err
+	}
+	var buf bytes.Buffer
+	buf.WriteByte(compressedFlag)
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	if buf.Len() >= len(data) {
+		return data, nil
+	}
+	return buf.Bytes(), nil
+}
+
+func (c compressingCodec) Unmarshal(data []byte, value interface{}) error {
+	if isCompressed(data) {
+		zr, err := gzip.NewReader(bytes.NewReader(data[1:]))
+		if err != nil {
+			return err
+		}
+		if data, err = ioutil.ReadAll(zr); err != nil {
+			return err
+		}
+	}
+	return c.Codec.Unmarshal(data, value)
+}
+
+func isCompressed(data []byte) bool {
+	return len(data) > 0 && data[0] == compressedFlag
+}
+
+// Report whether the value stored at key was compressed. Always false
+// unless the store was opened WithCompression.
+func (kvs *KVStore) IsCompressed(key string) (bool, error) {
+	compressed := false
+	err := kvs.view(func(tx *bolt.Tx) error {
+		data, err := kvs.lookup(tx, key)
+		if err == errExpired {
+			return ErrNotFound
+		} else if err != nil {
+			return err
+		}
+		// Peel off outer layers until reaching the compression layer.
+		for c := kvs.codec; ; {
+			switch layer := c.(type) {
+			case encryptingCodec:
+				if data, err = layer.open(data); err != nil {
+					return err
+				}
+				c = layer.Codec
+			case compressingCodec:
+				compressed = isCompressed(data)
+				return nil
+			default:
+				return nil
+			}
+		}
+	})
+	return compressed, err
+}
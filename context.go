@@ -0,0 +1,96 @@
+package kvs
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// How many entries ForEachContext visits between checks of its context,
+// and iterations between checks of their read timeout.
+const ctxCheckInterval = 256
+
+var ErrTimeout = errors.New("kvs: operation took longer than its timeout")
+
+// A guard counts entries visited by an iteration and stops it once the
+// store's read timeout has passed. The zero guard never stops anything.
+type guard struct {
+	deadline time.Time
+	n        int
+}
+
+// Return a guard for an iteration in a transaction that began at start.
+func (kvs *KVStore) readGuard(start time.Time) *guard {
+	if d := kvs.opts.readTimeout; d > 0 {
+		return &guard{deadline: start.Add(d)}
+	}
+	return &guard{}
+}
+
+// Count one entry, returning ErrTimeout if the deadline has passed.
+// The clock is only read every ctxCheckInterval entries.
+func (g *guard) check() error {
+	if g.deadline.IsZero() {
+		return nil
+	}
+	if g.n++; g.n%ctxCheckInterval == 0 && time.Now().After(g.deadline) {
+		return ErrTimeout
+	}
+	return nil
+}
+
+// Like Get, but returns ctx.Err() without touching the store if ctx is
+// already done.
+func (kvs *KVStore) GetContext(ctx context.Context, key string, value interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return kvs.Get(key, value)
+}
+
+// Like Put, but returns ctx.Err() without touching the store if ctx is
+// already done.
+func (kvs *KVStore) PutContext(ctx context.Context, key string, value interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return kvs.Put(key, value)
+}
+
+// Like Delete, but returns ctx.Err() without touching the store if ctx is
+// already done.
+func (kvs *KVStore) DeleteContext(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return kvs.Delete(key)
+}
+
+// Like ForEach, but stops with ctx.Err() once ctx is done. The context is
+// checked before starting and every few hundred entries after that.
+func (kvs *KVStore) ForEachContext(ctx context.Context, fn func(key string, raw []byte) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return kvs.view(func(tx *bolt.Tx) error {
+		expiry, now := tx.Bucket(kvs.expiryBucket), time.Now()
+		guard := kvs.readGuard(now)
+		n := 0
+		return tx.Bucket(kvs.bucket).ForEach(func(k, v []byte) error {
+			if n++; n%ctxCheckInterval == 0 {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+			}
+			if err := guard.check(); err != nil {
+				return err
+			}
+			if expired(expiry, k, now) {
+				return nil
+			}
+			return fn(string(k), v)
+		})
+	})
+}
@@ -0,0 +1,147 @@
+package kvs
+
+import (
+	"bytes"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// How many entries CopyTo moves per transaction.
+const copyBatchSize = 1000
+
+// Copy every entry whose key starts with prefix into dst, returning how
+// many were copied. An empty prefix copies everything. Values are copied
+// as stored, without decoding, so dst must use the same Codec (and
+// encryption key) to read them. TTLs are copied too, and expired entries
+// skipped. Entries already in dst under the same keys are replaced.
+//
+// The copy runs in batches of separate transactions, so it isn't a
+// snapshot: writes to the source during the copy may or may not be seen,
+// and an error part way leaves the earlier batches in dst.
+func (kvs *KVStore) CopyTo(dst *KVStore, prefix string) (n int, err error) {
+	defer func() { dst.logOp("CopyTo", prefix, err) }()
+	var after []byte
+	for {
+		batch, err := kvs.copyBatch([]byte(prefix), after)
+		if err != nil || len(batch) == 0 {
+			return n, err
+		}
+		err = dst.update(func(tx *bolt.Tx) error {
+			for _, e := range batch {
+				if err := dst.write(tx, string(e.key), e.data); err != nil {
+					return err
+				}
+				if err := dst.setExpiry(tx, string(e.key), e.expiresAt); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return n, err
+		}
+		n += len(batch)
+		after = batch[len(batch)-1].key
+	}
+}
+
+// Merge every entry of other into the store, returning how many keys
+// changed. Keys the store doesn't have, or only has expired, are copied
+// over as stored, TTL and all, as CopyTo does. For keys both have, resolve
+// gets the bytes stored on each side and returns the bytes to keep, which
+// are written in place of mine, keeping mine's TTL; returning mine itself,
+// or nil, leaves the key alone and doesn't count it. Values are compared
+// and written as stored, so both stores must use the same Codec, and
+// resolve decodes them itself if it needs to. Entries only the store has
+// are left as they are, and expired entries in other skipped.
+//
+// Like CopyTo, the merge runs in batches of separate transactions and
+// isn't a snapshot of other. resolve runs inside the store's write
+// transaction, so it should be quick and must not use either store, and
+// mine and theirs are only valid until it returns. An error from resolve,
+// or from a write, stops the merge; the earlier batches stay, and their
+// count is returned with the error.
+func (kvs *KVStore) Merge(other *KVStore, resolve func(key string, mine, theirs []byte) ([]byte, error)) (n int, err error) {
+	defer func() { kvs.logOp("Merge", "", err) }()
+	var after []byte
+	for {
+		batch, err := other.copyBatch(nil, after)
+		if err != nil || len(batch) == 0 {
+			return n, err
+		}
+		changed := 0
+		err = kvs.update(func(tx *bolt.Tx) error {
+			changed = 0
+			for _, e := range batch {
+				key := string(e.key)
+				mine, err := kvs.lookup(tx, key)
+				if err == ErrNotFound || err == errExpired {
+					if err := kvs.write(tx, key, e.data); err != nil {
+						return err
+					}
+					if err := kvs.setExpiry(tx, key, e.expiresAt); err != nil {
+						return err
+					}
+					changed++
+					continue
+				} else if err != nil {
+					return err
+				}
+				data, err := resolve(key, mine, e.data)
+				if err != nil {
+					return err
+				}
+				if data == nil || bytes.Equal(data, mine) {
+					continue
+				}
+				// data may alias mine, which write is about to overwrite.
+				if err := kvs.write(tx, key, append([]byte(nil), data...)); err != nil {
+					return err
+				}
+				changed++
+			}
+			return nil
+		})
+		if err != nil {
+			return n, err
+		}
+		n += changed
+		after = batch[len(batch)-1].key
+	}
+}
+
+// An entry as CopyTo and Merge move it: the stored bytes and expiry.
+type copyEntry struct {
+	key, data, expiresAt []byte
+}
+
+// Read up to copyBatchSize live entries whose keys start with p, copying
+// them out of the transaction, beginning after the key after if it isn't
+// nil.
+func (kvs *KVStore) copyBatch(p, after []byte) ([]copyEntry, error) {
+	var batch []copyEntry
+	err := kvs.view(func(tx *bolt.Tx) error {
+		batch = nil
+		cursor := tx.Bucket(kvs.bucket).Cursor()
+		expiry, now := tx.Bucket(kvs.expiryBucket), time.Now()
+		k, v := cursor.Seek(p)
+		if after != nil {
+			if k, v = cursor.Seek(after); k != nil && bytes.Equal(k, after) {
+				k, v = cursor.Next()
+			}
+		}
+		for ; k != nil && bytes.HasPrefix(k, p) && len(batch) < copyBatchSize; k, v = cursor.Next() {
+			if expired(expiry, k, now) {
+				continue
+			}
+			batch = append(batch, copyEntry{
+				key:       append([]byte(nil), k...),
+				data:      append([]byte(nil), v...),
+				expiresAt: append([]byte(nil), expiry.Get(k)...),
+			})
+		}
+		return nil
+	})
+	return batch, err
+}
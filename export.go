@@ -0,0 +1,192 @@
+package kvs
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// One line of the ExportJSON format.
+type exportEntry struct {
+	Key string `json:"key"`
+	// The value decoded with the store's Codec, when that's possible.
+	Value json.RawMessage `json:"value,omitempty"`
+	// The stored bytes, base64-encoded, when Value couldn't be decoded.
+	Raw     []byte     `json:"raw,omitempty"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// Write every entry to w as JSON lines:
+//
+//	{"key":"k","value":...,"expires":"2006-01-02T15:04:05Z"}
+//
+// Each value is decoded with the store's Codec into an interface{}. Gob
+// can rarely do that without knowing the concrete type, so values that
+// fail to decode are written as base64 "raw" bytes instead. "expires" is
+// only present for entries with a TTL.
+func (kvs *KVStore) ExportJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	return kvs.view(func(tx *bolt.Tx) error {
+		expiry, now := tx.Bucket(kvs.expiryBucket), time.Now()
+		return tx.Bucket(kvs.bucket).ForEach(func(k, v []byte) error {
+			if expired(expiry, k, now) {
+				return nil
+			}
+			e := exportEntry{Key: string(k)}
+			var value interface{}
+			if err := kvs.codec.Unmarshal(v, &value); err == nil {
+				e.Value, err = json.Marshal(value)
+				if err != nil {
+					e.Value = nil
+				}
+			}
+			if e.Value == nil {
+				e.Raw = v
+			}
+			if t, ok := expiresAt(expiry, k); ok {
+				e.Expires = &t
+			}
+			return enc.Encode(e)
+		})
+	})
+}
+
+// Write every entry to w, one per line as the key, " = ", then the value,
+// for eyeballing what's in a store while debugging. Values are decoded
+// into an interface{} as for ExportJSON and printed with %v. Byte slices,
+// which gob can only decode as such, are printed in hex after "hex:", and
+// values that won't decode either way, as with gob values of unregistered
+// types or bytes stored with PutRaw, as their stored bytes in hex after
+// "raw:". The format is meant for people and may change; use ExportJSON
+// for anything that has to read it back.
+func (kvs *KVStore) Dump(w io.Writer) error {
+	return kvs.view(func(tx *bolt.Tx) error {
+		return kvs.forEach(tx, time.Now(), func(key string, raw []byte) error {
+			var s string
+			var value interface{}
+			var b []byte
+			if err := kvs.codec.Unmarshal(raw, &value); err == nil {
+				if b, ok := value.([]byte); ok {
+					s = "hex:" + hex.EncodeToString(b)
+				} else {
+					s = fmt.Sprintf("%v", value)
+				}
+			} else if err := kvs.codec.Unmarshal(raw, &b); err == nil {
+				s = "hex:" + hex.EncodeToString(b)
+			} else {
+				s = "raw:" + hex.EncodeToString(raw)
+			}
+			_, err := fmt.Fprintf(w, "%s = %s\n", key, s)
+			return err
+		})
+	})
+}
+
+// How many entries ImportJSON writes per transaction.
+const importBatchSize = 1000
+
+// Read entries written by ExportJSON from r and put them in the store,
+// returning how many were imported. "raw" bytes are stored exactly. A
+// "value" is re-encoded with the store's Codec from its generic JSON form,
+// so numbers come back as float64 and structs as maps. Entries whose
+// "expires" time has passed are skipped, and existing entries with the
+// same keys are replaced.
+//
+// Entries are written in batches of separate transactions, so a big
+// import doesn't hold the write lock throughout. If r turns out to be
+// malformed part way, or a write fails, the batches already written stay
+// and their count is returned with the error.
+func (kvs *KVStore) ImportJSON(r io.Reader) (n int, err error) {
+	defer func() { kvs.logOp("ImportJSON", "", err) }()
+	return kvs.importJSON(r, true)
+}
+
+// Like ImportJSON, but entries whose keys are already live in the store
+// are skipped rather than replaced, and not counted.
+func (kvs *KVStore) ImportJSONNoReplace(r io.Reader) (n int, err error) {
+	defer func() { kvs.logOp("ImportJSONNoReplace", "", err) }()
+	return kvs.importJSON(r, false)
+}
+
+func (kvs *KVStore) importJSON(r io.Reader, replace bool) (int, error) {
+	type entry struct {
+		key       string
+		data      []byte
+		expiresAt time.Time
+	}
+	var batch []entry
+	n := 0
+	flush := func() error {
+		written := 0
+		err := kvs.update(func(tx *bolt.Tx) error {
+			written = 0
+			for _, e := range batch {
+				if !replace && kvs.has(tx, e.key) {
+					continue
+				}
+				var expiresAt []byte
+				if !e.expiresAt.IsZero() {
+					expiresAt = encodeExpiry(e.expiresAt)
+				}
+				if err := kvs.write(tx, e.key, e.data); err != nil {
+					return err
+				}
+				if err := kvs.setExpiry(tx, e.key, expiresAt); err != nil {
+					return err
+				}
+				written++
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		n += written
+		batch = batch[:0]
+		return nil
+	}
+	now := time.Now()
+	dec := json.NewDecoder(r)
+	for {
+		var e exportEntry
+		if err := dec.Decode(&e); err == io.EOF {
+			break
+		} else if err != nil {
+			return n, err
+		}
+		if e.Expires != nil && !e.Expires.After(now) {
+			continue
+		}
+		data := e.Raw
+		if data == nil {
+			var value interface{}
+			if err := json.Unmarshal(e.Value, &value); err != nil {
+				return n, err
+			}
+			var err error
+			if data, err = kvs.encode(e.Key, value); err != nil {
+				return n, err
+			}
+		}
+		imported := entry{key: e.Key, data: data}
+		if e.Expires != nil {
+			imported.expiresAt = *e.Expires
+		}
+		batch = append(batch, imported)
+		if len(batch) == importBatchSize {
+			if err := flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	if len(batch) > 0 {
+		if err := flush(); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
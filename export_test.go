@@ -0,0 +1,30 @@
+package kvs
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDumpPrintsBytesAsHex(t *testing.T) {
+	kvs := openTest(t)
+	if err := kvs.Put("bytes", []byte{0xca, 0xfe}); err != nil {
+		t.Fatal(err)
+	}
+	if err := kvs.PutRaw("raw", []byte{0x01, 0x02}); err != nil {
+		t.Fatal(err)
+	}
+	var i interface{} = "hello"
+	if err := kvs.Put("value", &i); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := kvs.Dump(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	want := []string{"bytes = hex:cafe", "raw = raw:0102", "value = hello"}
+	if strings.Join(lines, "|") != strings.Join(want, "|") {
+		t.Errorf("Dump wrote %q, want %q", lines, want)
+	}
+}
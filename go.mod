@@ -1,5 +1,7 @@
 module github.com/chrisschaaf/kvs
 
-go 1.13
+go 1.18
 
-require github.com/boltdb/bolt v1.3.1
+require go.etcd.io/bbolt v1.3.9
+
+require golang.org/x/sys v0.4.0 // indirect
@@ -0,0 +1,175 @@
+package kvs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var ErrNoIndex = errors.New("kvs: no such index")
+
+// Each index is a bucket nested in the meta bucket, named indexPrefix and
+// the index name. Its keys are the length of an indexed value as a
+// uvarint, the value, then the primary key, with empty values, so the
+// keys carrying one value sort together, whatever bytes the value holds.
+const indexPrefix = "index:"
+
+type index struct {
+	bucket  []byte
+	extract func(raw []byte) (string, error)
+}
+
+// Maintain an index called name over the store, mapping what extract
+// returns for each entry's stored bytes back to its key, for FindByIndex.
+// Existing entries are indexed straight away, in one transaction, and
+// from then on every write through the store updates the index in the
+// same transaction as the entry. An error from extract fails that write,
+// or CreateIndex itself. Entries for which extract returns "" aren't
+// indexed.
+//
+// Indexes aren't remembered across Open: extract is code, so call
+// CreateIndex again after each Open, which rebuilds the index from
+// scratch. raw is the value as stored, after the Codec and any
+// compression or encryption, and is only valid until extract returns.
+func (kvs *KVStore) CreateIndex(name string, extract func(raw []byte) (string, error)) error {
+	idx := &index{bucket: []byte(indexPrefix + name), extract: extract}
+	var prev *index
+	registered := false
+	err := kvs.update(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(kvs.metaBucket)
+		if err := meta.DeleteBucket(idx.bucket); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		b, err := meta.CreateBucket(idx.bucket)
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket(kvs.bucket).ForEach(func(k, v []byte) error {
+			value, err := extract(v)
+			if err != nil || value == "" {
+				return err
+			}
+			return b.Put(indexKey(value, string(k)), nil)
+		})
+		if err != nil {
+			return err
+		}
+		// Register the index while still holding the write lock, so no
+		// write can slip in between the build and the first reindex.
+		kvs.indexMu.Lock()
+		prev = kvs.indexes[name]
+		if kvs.indexes == nil {
+			kvs.indexes = make(map[string]*index)
+		}
+		kvs.indexes[name] = idx
+		kvs.indexMu.Unlock()
+		registered = true
+		return nil
+	})
+	if err != nil && registered {
+		// The commit failed after all; put back whatever was there.
+		kvs.indexMu.Lock()
+		if prev != nil {
+			kvs.indexes[name] = prev
+		} else {
+			delete(kvs.indexes, name)
+		}
+		kvs.indexMu.Unlock()
+	}
+	return err
+}
+
+// Return the keys of every live entry whose indexed value is value, in key
+// order, from one read transaction. Returns ErrNoIndex if CreateIndex
+// hasn't been called for name since the store was opened.
+func (kvs *KVStore) FindByIndex(name, value string) ([]string, error) {
+	kvs.indexMu.RLock()
+	idx := kvs.indexes[name]
+	kvs.indexMu.RUnlock()
+	if idx == nil {
+		return nil, ErrNoIndex
+	}
+	keys := []string{}
+	err := kvs.view(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(kvs.metaBucket).Bucket(idx.bucket).Cursor()
+		expiry, now := tx.Bucket(kvs.expiryBucket), time.Now()
+		p := indexKey(value, "")
+		for k, _ := cursor.Seek(p); k != nil && bytes.HasPrefix(k, p); k, _ = cursor.Next() {
+			if key := k[len(p):]; !expired(expiry, key, now) {
+				keys = append(keys, string(key))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func indexKey(value, key string) []byte {
+	k := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(value)+len(key))
+	k = k[:binary.PutUvarint(k, uint64(len(value)))]
+	k = append(k, value...)
+	return append(k, key...)
+}
+
+// Bring every index up to date within tx for key changing from old to
+// new, either of which is nil if there is no entry. Call it before the
+// entry itself changes.
+func (kvs *KVStore) reindex(tx *bolt.Tx, key string, old, new []byte) error {
+	kvs.indexMu.RLock()
+	defer kvs.indexMu.RUnlock()
+	if len(kvs.indexes) == 0 {
+		return nil
+	}
+	meta := tx.Bucket(kvs.metaBucket)
+	for _, idx := range kvs.indexes {
+		var before, after string
+		var err error
+		if old != nil {
+			if before, err = idx.extract(old); err != nil {
+				return err
+			}
+		}
+		if new != nil {
+			if after, err = idx.extract(new); err != nil {
+				return err
+			}
+		}
+		if before == after {
+			continue
+		}
+		b := meta.Bucket(idx.bucket)
+		if before != "" {
+			if err := b.Delete(indexKey(before, key)); err != nil {
+				return err
+			}
+		}
+		if after != "" {
+			if err := b.Put(indexKey(after, key), nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Empty every index within tx, for Clear.
+func (kvs *KVStore) clearIndexes(tx *bolt.Tx) error {
+	kvs.indexMu.RLock()
+	defer kvs.indexMu.RUnlock()
+	meta := tx.Bucket(kvs.metaBucket)
+	for _, idx := range kvs.indexes {
+		if err := meta.DeleteBucket(idx.bucket); err != nil {
+			return err
+		}
+		if _, err := meta.CreateBucket(idx.bucket); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package kvs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIndexValuesWithZeroBytes(t *testing.T) {
+	kvs := openTest(t)
+	err := kvs.CreateIndex("raw", func(raw []byte) (string, error) {
+		return string(raw), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kvs.PutRaw("k1", []byte("a\x00x")); err != nil {
+		t.Fatal(err)
+	}
+	if err := kvs.PutRaw("k2", []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	for value, want := range map[string][]string{
+		"a":      {"k2"},
+		"a\x00x": {"k1"},
+		"a\x00":  {},
+	} {
+		keys, err := kvs.FindByIndex("raw", value)
+		if err != nil || !reflect.DeepEqual(keys, want) {
+			t.Errorf("FindByIndex(%q) = %q, %v, want %q", value, keys, err, want)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package kvs
+
+import (
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// An Iterator walks a store's entries in key order, pulling one at a time:
+//
+//	it, err := kvs.Iterator()
+//	...
+//	defer it.Close()
+//	for it.Next() {
+//		fmt.Println(it.Key(), len(it.Value()))
+//	}
+//
+// It holds a read transaction from Iterator until Close, and sees the
+// store as it was when that began. Close it promptly: while it is open a
+// writer that needs to grow the file waits for it, so an Iterator left
+// open, or one used to write to the same store from the same goroutine,
+// can block writers for good. An Iterator is not safe for use by several
+// goroutines at once.
+type Iterator struct {
+	kvs    *KVStore
+	tx     *bolt.Tx
+	cursor *bolt.Cursor
+	expiry *bolt.Bucket
+	now    time.Time
+	// The current entry; key is nil before the first Next and at the end.
+	key, value []byte
+	started    bool
+}
+
+// Start iterating over the store. The Iterator must be closed.
+func (kvs *KVStore) Iterator() (*Iterator, error) {
+	if err := kvs.prepare(); err != nil {
+		return nil, err
+	}
+	if err := kvs.lockDB(); err != nil {
+		return nil, err
+	}
+	tx, err := kvs.db.Begin(false)
+	kvs.root.dbMu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	return &Iterator{
+		kvs:    kvs,
+		tx:     tx,
+		cursor: tx.Bucket(kvs.bucket).Cursor(),
+		expiry: tx.Bucket(kvs.expiryBucket),
+		now:    time.Now(),
+	}, nil
+}
+
+// Advance to the next unexpired entry, reporting whether there is one.
+func (it *Iterator) Next() bool {
+	if it.tx == nil {
+		return false
+	}
+	var k, v []byte
+	if !it.started {
+		k, v = it.cursor.First()
+		it.started = true
+	} else if it.key != nil {
+		k, v = it.cursor.Next()
+	}
+	for k != nil && expired(it.expiry, k, it.now) {
+		k, v = it.cursor.Next()
+	}
+	it.key, it.value = k, v
+	return k != nil
+}
+
+// Return the current entry's key.
+func (it *Iterator) Key() string {
+	return string(it.key)
+}
+
+// Return the current entry's bytes as stored. They are only valid until
+// the next call to Next or Close.
+func (it *Iterator) Value() []byte {
+	return it.value
+}
+
+// Decode the current entry into value, which must be pointer-typed.
+func (it *Iterator) Decode(value interface{}) error {
+	return it.kvs.decode(string(it.key), it.value, value)
+}
+
+// End the read transaction. Next returns false afterwards. Closing more
+// than once is fine.
+func (it *Iterator) Close() error {
+	if it.tx == nil {
+		return nil
+	}
+	err := it.tx.Rollback()
+	it.tx, it.cursor, it.expiry = nil, nil, nil
+	it.key, it.value = nil, nil
+	return err
+}
@@ -2,90 +2,887 @@ package kvs
 
 import (
 	"bytes"
-	"encoding/gob"
 	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/boltdb/bolt"
+	bolt "go.etcd.io/bbolt"
 )
 
 type KVStore struct {
-	db *bolt.DB
+	// Entries the sweeper has deleted from this store and, on the root,
+	// sweeps it has run. Updated atomically, so kept first for alignment.
+	swept, sweeps uint64
+
+	db           *bolt.DB
+	bucket       []byte
+	expiryBucket []byte
+	metaBucket   []byte
+	codec        Codec
+	watch        *watchers
+	readOnly     bool
+	// The options the root store was opened with, shared by namespaces.
+	opts *options
+	// Set WithReadCache; nil otherwise.
+	cache *readCache
+	// Indexes set up by CreateIndex, by name.
+	indexMu sync.RWMutex
+	indexes map[string]*index
+	// Set by OpenTemp; removed on Close.
+	tempDir string
+	// Stops the background sweeper, if any, and waits for it to exit.
+	stopSweep func()
+
+	// The store returned by Open, which owns db and its namespaces.
+	root *KVStore
+	// Held for reading while db is in use, and for writing by Reopen and
+	// Close. Not reentrant: code run inside a transaction that calls back
+	// into the store can deadlock against a waiting Reopen. Only the
+	// root's is used.
+	dbMu sync.RWMutex
+	// Taken by WithLock and WithRLock. Only the root's is used.
+	appMu      sync.RWMutex
+	nsMu       sync.Mutex
+	namespaces map[string]*KVStore
+	// Set on handles whose Close must leave db open.
+	shared bool
+	// Set by New: db belongs to the caller, so Close leaves it open.
+	borrowed bool
+	// Set to 1 on the root store by Close.
+	isClosed int32
+	// Set to 1 once the buckets are known to exist.
+	ready   int32
+	readyMu sync.Mutex
+	// Set if prepare had to create the store's bucket.
+	created bool
 }
 
 var (
-	ErrNotFound = errors.New("kvs: key not found")
-	ErrBadValue = errors.New("kvs: bad value")
-	bucketName  = []byte("kvs")
+	ErrNotFound  = errors.New("kvs: key not found")
+	ErrBadValue  = errors.New("kvs: bad value")
+	ErrBadBucket = errors.New("kvs: bad bucket name")
+	ErrNoBucket  = errors.New("kvs: bucket not found")
+	ErrLocked    = errors.New("kvs: database is locked by another process")
+	ErrReadOnly  = errors.New("kvs: store is read-only")
+	// Returned for values over the limit set WithMaxValueSize.
+	ErrValueTooLarge = errors.New("kvs: value too large")
+	ErrClosed        = errors.New("kvs: store is closed")
+	ErrBorrowed      = errors.New("kvs: database belongs to the caller")
+	ErrBadKey        = errors.New("kvs: bad key")
+	bucketName       = []byte("kvs")
+	// Returned internally by lookup for entries past their TTL.
+	errExpired = errors.New("kvs: key expired")
 )
 
 // Open a Key-Value Store. Create it if it doesn't exist.
-// Path = full path, with all leading directories already existing.
-// Can only be used by one process at a time.
-func Open(path string) (*KVStore, error) {
-	opts := &bolt.Options{
-		Timeout: 50 * time.Millisecond,
+// Path = full path, with all leading directories already existing unless
+// opened WithMkdirAll.
+// Can only be used by one process at a time, unless opened WithReadOnly.
+// Returns ErrLocked if another process still holds the file once the
+// timeout (see WithTimeout) runs out.
+func Open(path string, opts ...Option) (*KVStore, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
 	}
-	if db, err := bolt.Open(path, 0640, opts); err != nil {
+	kvs, err := newStore(&o)
+	if err != nil {
 		return nil, err
-	} else {
-		err := db.Update(func(tx *bolt.Tx) error {
-			_, err := tx.CreateBucketIfNotExists(bucketName)
+	}
+	if o.mkdirAll {
+		if err := os.MkdirAll(filepath.Dir(path), o.dirMode); err != nil {
+			return nil, err
+		}
+	}
+	if err := kvs.openDB(path); err != nil {
+		return nil, err
+	}
+	if err := kvs.setup(); err != nil {
+		kvs.db.Close()
+		return nil, err
+	}
+	kvs.tuneDB()
+	return kvs, nil
+}
+
+// Open the file at path as the root store's db, as its options say,
+// compacting it first if WithAutoCompact asks.
+func (kvs *KVStore) openDB(path string) error {
+	o := kvs.opts
+	boltOpts := &bolt.Options{
+		Timeout:         o.timeout,
+		ReadOnly:        o.readOnly,
+		InitialMmapSize: o.initialMmapSize,
+		PageSize:        o.pageSize,
+		NoFreelistSync:  o.noFreelistSync,
+		FreelistType:    o.freelistType,
+	}
+	db, err := bolt.Open(path, o.fileMode, boltOpts)
+	if err == bolt.ErrTimeout {
+		return ErrLocked
+	} else if err != nil {
+		return err
+	}
+	kvs.db = db
+	if o.autoCompact > 0 && !o.readOnly {
+		if err := kvs.autoCompact(boltOpts); err != nil {
+			kvs.db.Close()
 			return err
-		})
-		if err != nil {
+		}
+	}
+	return nil
+}
+
+// Apply the options that live on the *bolt.DB itself, once the store is
+// set up.
+func (kvs *KVStore) tuneDB() {
+	o := kvs.opts
+	kvs.db.NoSync = o.noSync
+	if o.flushCount > 0 {
+		kvs.db.MaxBatchSize = o.flushCount
+	}
+	if o.flushInterval > 0 {
+		kvs.db.MaxBatchDelay = o.flushInterval
+	}
+}
+
+// Like Open, but if the store's bucket doesn't exist yet, as in a file
+// that is brand new, put defaults in it before returning. An existing
+// store is opened as it is and defaults ignored, so changes made since
+// the first run are never overwritten. The seeding is a separate
+// transaction from creating the bucket: if it fails, the error is returned
+// and the store closed, but the empty bucket stays and a later call won't
+// seed it. Values are encoded as PutAll does.
+func OpenWithDefaults(path string, defaults map[string]interface{}, opts ...Option) (*KVStore, error) {
+	kvs, err := Open(path, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if kvs.created && len(defaults) > 0 {
+		if err := kvs.PutAll(defaults); err != nil {
+			kvs.Close()
 			return nil, err
-		} else {
-			return &KVStore{db: db}, nil
 		}
 	}
+	return kvs, nil
+}
+
+// Wrap a Bolt database the caller already has open, keeping entries in
+// the named bucket, which is created if needed. The store is read-only if
+// db is. Options that configure the file or the *bolt.DB, such as
+// WithTimeout, WithReadOnly, WithNoSync and WithAutoFlush, have no effect,
+// and bucket overrides WithBucket. Close leaves db open; the
+// caller still owns it and must close it after the store.
+func New(db *bolt.DB, bucket string, opts ...Option) (*KVStore, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	o.bucket = bucket
+	o.readOnly = db.IsReadOnly()
+	kvs, err := newStore(&o)
+	if err != nil {
+		return nil, err
+	}
+	kvs.db = db
+	kvs.borrowed = true
+	if err := kvs.setup(); err != nil {
+		return nil, err
+	}
+	return kvs, nil
+}
+
+func defaultOptions() options {
+	return options{
+		bucket:   string(bucketName),
+		timeout:  50 * time.Millisecond,
+		fileMode: 0640,
+		codec:    GobCodec{},
+	}
+}
+
+// Build a root store from o, with no database yet.
+func newStore(o *options) (*KVStore, error) {
+	if !validBucket(o.bucket) {
+		return nil, ErrBadBucket
+	}
+	codec, err := o.buildCodec()
+	if err != nil {
+		return nil, err
+	}
+	kvs := &KVStore{
+		bucket:       []byte(o.bucket),
+		expiryBucket: []byte(o.bucket + expirySuffix),
+		metaBucket:   []byte(o.bucket + metaSuffix),
+		codec:        codec,
+		watch:        &watchers{},
+		cache:        newReadCache(o.readCache),
+		readOnly:     o.readOnly,
+		opts:         o,
+	}
+	kvs.root = kvs
+	return kvs, nil
+}
+
+// Get a newly opened root store ready for use: create its buckets, bring
+// its schema up to date and start the background sweeper if the options
+// ask for one.
+func (kvs *KVStore) setup() error {
+	if err := kvs.prepare(); err != nil {
+		return err
+	}
+	if err := kvs.migrate(kvs.opts.migrations); err != nil {
+		return err
+	}
+	if kvs.opts.sweepInterval > 0 && !kvs.readOnly {
+		kvs.stopSweep = kvs.startSweeper(kvs.opts.sweepInterval)
+	}
+	return nil
+}
+
+func validBucket(name string) bool {
+	return name != "" && !strings.HasSuffix(name, expirySuffix) && !strings.HasSuffix(name, metaSuffix)
+}
+
+// Make sure the store's buckets exist, creating them the first time
+// through. Read-only stores can't create them, so they must already exist.
+func (kvs *KVStore) prepare() error {
+	if kvs.closed() {
+		return ErrClosed
+	}
+	if atomic.LoadInt32(&kvs.ready) == 1 {
+		return nil
+	}
+	kvs.readyMu.Lock()
+	defer kvs.readyMu.Unlock()
+	if kvs.ready == 1 {
+		return nil
+	}
+	if !validBucket(string(kvs.bucket)) {
+		return ErrBadBucket
+	}
+	if err := kvs.lockDB(); err != nil {
+		return err
+	}
+	defer kvs.root.dbMu.RUnlock()
+	var err error
+	if kvs.readOnly {
+		err = kvs.db.View(func(tx *bolt.Tx) error {
+			if tx.Bucket(kvs.bucket) == nil {
+				return ErrNoBucket
+			}
+			return nil
+		})
+	} else {
+		err = kvs.db.Update(func(tx *bolt.Tx) error {
+			kvs.created = tx.Bucket(kvs.bucket) == nil
+			for _, name := range [][]byte{kvs.bucket, kvs.expiryBucket, kvs.metaBucket} {
+				if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	if err == nil {
+		atomic.StoreInt32(&kvs.ready, 1)
+	}
+	return err
 }
 
-// Puts an entry into the Key-Value Store. It is gob-encoded.
+// Puts an entry into the Key-Value Store. It is encoded with the store's
+// Codec, gob unless opened WithCodec.
 // Nil values are not allowed (empty strings valid)
-func (kvs *KVStore) Put(key string, value interface{}) error {
-	if value == nil {
-		return ErrBadValue
+// Values the Codec can't encode, such as channels and funcs, return an
+// *EncodeError naming their type.
+// []byte and string values go through the Codec like anything else; use
+// PutRaw to store bytes without any framing.
+// Any TTL previously set on key is cleared; the entry never expires,
+// unless the store was opened WithDefaultTTL. An expired entry not yet
+// swept is replaced like any other; PutReportExpired says when that
+// happened.
+func (kvs *KVStore) Put(key string, value interface{}) (err error) {
+	defer func() { kvs.logOp("Put", key, err) }()
+	if obs := kvs.opts.observer; obs != nil {
+		defer func(start time.Time) { obs.ObservePut(key, time.Since(start), err) }(time.Now())
 	}
-	var buf bytes.Buffer
-	if err := gob.NewEncoder(&buf).Encode(value); err != nil {
+	data, err := kvs.encode(key, value)
+	if err != nil {
 		return err
 	}
-	return kvs.db.Update(func(tx *bolt.Tx) error {
-		return tx.Bucket(bucketName).Put([]byte(key), buf.Bytes())
+	return kvs.update(func(tx *bolt.Tx) error {
+		return kvs.put(tx, key, data)
 	})
 }
 
+// Write encoded data at key within tx as a fresh entry, replacing any
+// expiry with the default TTL set WithDefaultTTL, or clearing it.
+func (kvs *KVStore) put(tx *bolt.Tx, key string, data []byte) error {
+	if err := kvs.validateKey(key); err != nil {
+		return err
+	}
+	return kvs.putEntry(tx, key, data)
+}
+
+// Like put, but for keys the store made up itself, which the validator set
+// WithKeyValidator never sees.
+func (kvs *KVStore) putEntry(tx *bolt.Tx, key string, data []byte) error {
+	var expiresAt []byte
+	if d := kvs.opts.defaultTTL; d > 0 {
+		expiresAt = encodeExpiry(time.Now().Add(d))
+	}
+	if err := kvs.setExpiry(tx, key, expiresAt); err != nil {
+		return err
+	}
+	return kvs.writeEntry(tx, key, data)
+}
+
+// Write encoded data at key within tx, leaving any expiry alone.
+// Watchers hear about it once tx commits.
+func (kvs *KVStore) write(tx *bolt.Tx, key string, data []byte) error {
+	if err := kvs.validateKey(key); err != nil {
+		return err
+	}
+	return kvs.writeEntry(tx, key, data)
+}
+
+// Like write, but without checking key, for the same keys as putEntry.
+func (kvs *KVStore) writeEntry(tx *bolt.Tx, key string, data []byte) error {
+	if max := kvs.opts.maxValueSize; max > 0 && len(data) > max {
+		return ErrValueTooLarge
+	}
+	b := tx.Bucket(kvs.bucket)
+	if err := kvs.reindex(tx, key, b.Get([]byte(key)), data); err != nil {
+		return err
+	}
+	if err := b.Put([]byte(key), data); err != nil {
+		return err
+	}
+	kvs.cache.invalidate(tx, key)
+	kvs.changed(tx, OpPut, key)
+	return kvs.logChange(tx, key)
+}
+
+// Run fn in a write transaction, failing fast with ErrReadOnly when the
+// store was opened WithReadOnly.
+func (kvs *KVStore) update(fn func(*bolt.Tx) error) error {
+	if kvs.readOnly {
+		return ErrReadOnly
+	}
+	if err := kvs.prepare(); err != nil {
+		return err
+	}
+	if err := kvs.lockDB(); err != nil {
+		return err
+	}
+	defer kvs.root.dbMu.RUnlock()
+	return kvs.db.Update(fn)
+}
+
+// Like update, but through Bolt's Batch.
+func (kvs *KVStore) batch(fn func(*bolt.Tx) error) error {
+	if kvs.readOnly {
+		return ErrReadOnly
+	}
+	if err := kvs.prepare(); err != nil {
+		return err
+	}
+	if err := kvs.lockDB(); err != nil {
+		return err
+	}
+	defer kvs.root.dbMu.RUnlock()
+	return kvs.db.Batch(fn)
+}
+
+// Run fn in a read transaction.
+func (kvs *KVStore) view(fn func(*bolt.Tx) error) error {
+	if err := kvs.prepare(); err != nil {
+		return err
+	}
+	if err := kvs.lockDB(); err != nil {
+		return err
+	}
+	defer kvs.root.dbMu.RUnlock()
+	return kvs.db.View(fn)
+}
+
+// Hold off Reopen and Close while db is in use, or fail with ErrClosed if
+// the store is closed already. Release with kvs.root.dbMu.RUnlock.
+func (kvs *KVStore) lockDB() error {
+	kvs.root.dbMu.RLock()
+	if kvs.closed() {
+		kvs.root.dbMu.RUnlock()
+		return ErrClosed
+	}
+	return nil
+}
+
+// Return db as it is now, for uses that don't need to hold off Reopen.
+func (kvs *KVStore) currentDB() *bolt.DB {
+	kvs.root.dbMu.RLock()
+	defer kvs.root.dbMu.RUnlock()
+	return kvs.db
+}
+
+// Encode a value for storage at key. Nil values are rejected with
+// ErrBadValue, ones the validator set WithValueValidator rejects with its
+// error, and anything else the Codec can't handle with an *EncodeError.
+func (kvs *KVStore) encode(key string, value interface{}) ([]byte, error) {
+	if value == nil {
+		return nil, ErrBadValue
+	}
+	if err := kvs.validate(key, value); err != nil {
+		return nil, err
+	}
+	return kvs.marshal(value)
+}
+
+// Run the validator set WithValueValidator, if any, on value for key.
+func (kvs *KVStore) validate(key string, value interface{}) error {
+	if v := kvs.opts.validator; v != nil {
+		return v(key, value)
+	}
+	return nil
+}
+
+// Reject the empty key with ErrBadKey, and run the validator set
+// WithKeyValidator, if any, on the rest.
+func (kvs *KVStore) validateKey(key string) error {
+	if key == "" {
+		return ErrBadKey
+	}
+	if v := kvs.opts.keyValidator; v != nil {
+		return v(key)
+	}
+	return nil
+}
+
+// Encode a non-nil value with the Codec, like encode but unvalidated.
+func (kvs *KVStore) marshal(value interface{}) ([]byte, error) {
+	t := reflect.TypeOf(value)
+	if unencodable(t) {
+		return nil, &EncodeError{Type: t}
+	}
+	data, err := kvs.codec.Marshal(value)
+	if err != nil {
+		return nil, &EncodeError{Type: t, Err: err}
+	}
+	return data, nil
+}
+
+// Decode data stored at key into value, wrapping any Codec failure in a
+// *DecodeError. ErrDecrypt and ErrChecksumMismatch are passed through as
+// they are: the data can't be trusted, whatever value's type.
+func (kvs *KVStore) decode(key string, data []byte, value interface{}) error {
+	if err := kvs.codec.Unmarshal(data, value); err == ErrDecrypt || err == ErrChecksumMismatch {
+		return err
+	} else if err != nil {
+		return &DecodeError{Key: key, Type: reflect.TypeOf(value), Err: err}
+	}
+	return nil
+}
+
 // Return an entry from the Key-Value Store
 // Value must be pointer-typed.
 // No matching values returns ErrNotFound
-func (kvs *KVStore) Get(key string, value interface{}) error {
-	return kvs.db.View(func(tx *bolt.Tx) error {
-		cursor := tx.Bucket(bucketName).Cursor()
-		if k, v := cursor.Seek([]byte(key)); k == nil || string(k) != key {
-			return ErrNotFound
-		} else if value == nil {
+// Expired entries also return ErrNotFound and are deleted.
+// Values that can't be decoded into value, usually because it has the wrong
+// type, return a *DecodeError matching ErrDecode.
+func (kvs *KVStore) Get(key string, value interface{}) (err error) {
+	if obs := kvs.opts.observer; obs != nil {
+		defer func(start time.Time) { obs.ObserveGet(key, time.Since(start), err) }(time.Now())
+	}
+	if kvs.cache != nil {
+		var data []byte
+		if data, err = kvs.cached(key); err == nil && value != nil {
+			err = kvs.decode(key, data, value)
+		}
+	} else {
+		err = kvs.view(func(tx *bolt.Tx) error {
+			return kvs.get(tx, key, value)
+		})
+	}
+	if err == errExpired {
+		kvs.purge(key)
+		err = ErrNotFound
+	}
+	if err == ErrNotFound && kvs.opts.loader != nil {
+		return kvs.load(key, value)
+	}
+	return err
+}
+
+// Fetch key with the loader set WithBackingStore, keep a copy, and decode
+// it into value.
+func (kvs *KVStore) load(key string, value interface{}) error {
+	data, err := kvs.opts.loader(key)
+	if errors.Is(err, ErrNotFound) || err == nil && data == nil {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+	if !kvs.readOnly {
+		if err := kvs.PutRaw(key, data); err != nil {
+			return err
+		}
+	}
+	if value == nil {
+		return nil
+	}
+	return kvs.decode(key, data, value)
+}
+
+// Return the entry at key decoded into a fresh interface{}, for callers
+// that don't know its type up front. With JSONCodec any entry works, coming
+// back as the type it was stored as if that was registered with
+// RegisterJSON, and otherwise as maps, slices, strings, float64s and
+// bools. Gob can only decode
+// values that were stored as interfaces, by passing Put a pointer to an
+// interface{} holding them, and their concrete types must be registered
+// with Register first. Fails like Get.
+func (kvs *KVStore) GetValue(key string) (interface{}, error) {
+	var value interface{}
+	if err := kvs.Get(key, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// Return the entry at key decoded into a generic map, for tooling that
+// shows records without knowing their types. With JSONCodec this works for
+// any entry stored as a JSON object, structs included. Gob keeps Go types,
+// so the entry must have been stored as a map[string]interface{}, and the
+// concrete types of its values registered with Register; a struct or a
+// map of another type fails with a *DecodeError. Fails like Get otherwise.
+func (kvs *KVStore) GetMap(key string) (map[string]interface{}, error) {
+	var m map[string]interface{}
+	if err := kvs.Get(key, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Like Get, but a missing or expired key stores def in value instead of
+// returning ErrNotFound. def may be a value of value's element type or a
+// pointer to one; a nil def stores the zero value. A def that doesn't fit
+// returns ErrBadValue.
+func (kvs *KVStore) GetDefault(key string, value interface{}, def interface{}) error {
+	err := kvs.Get(key, value)
+	if err != ErrNotFound {
+		return err
+	}
+	dst := reflect.ValueOf(value)
+	if dst.Kind() != reflect.Ptr || dst.IsNil() {
+		return ErrBadValue
+	}
+	dst = dst.Elem()
+	if def == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+	src := reflect.ValueOf(def)
+	if src.Kind() == reflect.Ptr && !src.Type().AssignableTo(dst.Type()) && !src.IsNil() {
+		src = src.Elem()
+	}
+	if !src.Type().AssignableTo(dst.Type()) {
+		return ErrBadValue
+	}
+	dst.Set(src)
+	return nil
+}
+
+// Decode the value at key within tx, failing like lookup.
+func (kvs *KVStore) get(tx *bolt.Tx, key string, value interface{}) error {
+	if v, err := kvs.lookup(tx, key); err != nil {
+		return err
+	} else if value == nil {
+		return nil
+	} else {
+		return kvs.decode(key, v, value)
+	}
+}
+
+// Find the raw value stored at key within tx. Returns ErrNotFound for
+// missing keys and errExpired for entries past their TTL, which the caller
+// should purge once tx is closed.
+func (kvs *KVStore) lookup(tx *bolt.Tx, key string) ([]byte, error) {
+	cursor := tx.Bucket(kvs.bucket).Cursor()
+	if k, v := cursor.Seek([]byte(key)); k == nil || string(k) != key {
+		return nil, ErrNotFound
+	} else if expired(tx.Bucket(kvs.expiryBucket), k, time.Now()) {
+		return nil, errExpired
+	} else {
+		return v, nil
+	}
+}
+
+// Report whether a key exists in the Key-Value Store.
+// The value is never decoded. Missing keys are not an error.
+func (kvs *KVStore) Has(key string) (bool, error) {
+	found := false
+	err := kvs.view(func(tx *bolt.Tx) error {
+		found = kvs.has(tx, key)
+		return nil
+	})
+	return found, err
+}
+
+// Report whether an unexpired entry exists at key within tx.
+func (kvs *KVStore) has(tx *bolt.Tx, key string) bool {
+	cursor := tx.Bucket(kvs.bucket).Cursor()
+	if k, _ := cursor.Seek([]byte(key)); k != nil && string(k) == key {
+		return !expired(tx.Bucket(kvs.expiryBucket), k, time.Now())
+	}
+	return false
+}
+
+// Return every key in the Key-Value Store, in byte-sorted order.
+// All keys are held in memory at once, so this is best suited to small stores.
+// An empty store returns an empty slice.
+func (kvs *KVStore) Keys() ([]string, error) {
+	return kvs.KeysWithPrefix("")
+}
+
+// Return every key starting with prefix, in byte-sorted order.
+// An empty prefix matches every key, like Keys.
+func (kvs *KVStore) KeysWithPrefix(prefix string) ([]string, error) {
+	keys := []string{}
+	err := kvs.view(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(kvs.bucket).Cursor()
+		expiry, now := tx.Bucket(kvs.expiryBucket), time.Now()
+		p := []byte(prefix)
+		for k, _ := cursor.Seek(p); k != nil && bytes.HasPrefix(k, p); k, _ = cursor.Next() {
+			if !expired(expiry, k, now) {
+				keys = append(keys, string(k))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Return the number of entries in the Key-Value Store without iterating.
+// Expired entries that haven't been deleted yet are still counted.
+func (kvs *KVStore) Count() (int, error) {
+	n := 0
+	err := kvs.view(func(tx *bolt.Tx) error {
+		n = tx.Bucket(kvs.bucket).Stats().KeyN
+		return nil
+	})
+	return n, err
+}
+
+// Return the number of live entries whose keys start with prefix, walking
+// just those keys with a cursor and never decoding or collecting them.
+// Unlike Count, expired entries are skipped. An empty prefix counts every
+// live entry, which visits the whole store.
+func (kvs *KVStore) CountPrefix(prefix string) (int, error) {
+	n := 0
+	err := kvs.view(func(tx *bolt.Tx) error {
+		n = 0
+		cursor := tx.Bucket(kvs.bucket).Cursor()
+		expiry, now := tx.Bucket(kvs.expiryBucket), time.Now()
+		p := []byte(prefix)
+		for k, _ := cursor.Seek(p); k != nil && bytes.HasPrefix(k, p); k, _ = cursor.Next() {
+			if !expired(expiry, k, now) {
+				n++
+			}
+		}
+		return nil
+	})
+	return n, err
+}
+
+// Call fn for every entry in the Key-Value Store, in key order, within a
+// single read transaction. raw is the encoded value and is only valid
+// until fn returns. A non-nil error from fn stops iteration and is returned.
+// Expired entries are skipped. Stops with ErrTimeout if the store was
+// opened WithReadTimeout and fn is too slow.
+func (kvs *KVStore) ForEach(fn func(key string, raw []byte) error) error {
+	return kvs.view(func(tx *bolt.Tx) error {
+		return kvs.forEach(tx, time.Now(), fn)
+	})
+}
+
+// Call fn for every unexpired entry within tx, which began at start.
+func (kvs *KVStore) forEach(tx *bolt.Tx, start time.Time, fn func(key string, raw []byte) error) error {
+	expiry, now := tx.Bucket(kvs.expiryBucket), time.Now()
+	guard := kvs.readGuard(start)
+	return tx.Bucket(kvs.bucket).ForEach(func(k, v []byte) error {
+		if err := guard.check(); err != nil {
+			return err
+		}
+		if expired(expiry, k, now) {
 			return nil
-		} else {
-			decoder := gob.NewDecoder(bytes.NewReader(v))
-			return decoder.Decode(value)
 		}
+		return fn(string(k), v)
 	})
 }
 
 // Delete a key from the Key-Value Store.
-// Returns ErrNotFound like Get.
-func (kvs *KVStore) Delete(key string) error {
-	return kvs.db.Update(func(tx *bolt.Tx) error {
-		cursor := tx.Bucket(bucketName).Cursor()
-		if k, _ := cursor.Seek([]byte(key)); k == nil || string(k) != key {
-			return ErrNotFound
-		} else {
-			return cursor.Delete()
-		}
+// Returns ErrNotFound like Get, including for expired entries.
+func (kvs *KVStore) Delete(key string) (err error) {
+	defer func() { kvs.logOp("Delete", key, err) }()
+	if obs := kvs.opts.observer; obs != nil {
+		defer func(start time.Time) { obs.ObserveDelete(key, time.Since(start), err) }(time.Now())
+	}
+	found := false
+	err = kvs.update(func(tx *bolt.Tx) error {
+		var err error
+		found, err = kvs.delete(tx, key)
+		return err
 	})
+	if err == nil && !found {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Delete key and its expiry within tx.
+// Reports whether an unexpired entry was removed.
+func (kvs *KVStore) delete(tx *bolt.Tx, key string) (bool, error) {
+	cursor := tx.Bucket(kvs.bucket).Cursor()
+	if k, v := cursor.Seek([]byte(key)); k == nil || string(k) != key {
+		return false, nil
+	} else {
+		expiry := tx.Bucket(kvs.expiryBucket)
+		live := !expired(expiry, k, time.Now())
+		if err := kvs.reindex(tx, key, v, nil); err != nil {
+			return false, err
+		}
+		if err := cursor.Delete(); err != nil {
+			return false, err
+		}
+		kvs.cache.invalidate(tx, key)
+		kvs.changed(tx, OpDelete, key)
+		if err := kvs.logChange(tx, key); err != nil {
+			return false, err
+		}
+		return live, expiry.Delete([]byte(key))
+	}
 }
 
+// Report whether Close has been called on the store or the one it came
+// from.
+func (kvs *KVStore) closed() bool {
+	return atomic.LoadInt32(&kvs.root.isClosed) == 1
+}
+
+// Close the database file and open it again with the same options, so a
+// read-only store sees what other processes have committed since. Waits
+// for transactions in flight; other calls wait for it. Stores from New
+// return ErrBorrowed. If the file can't be reopened, the store is closed.
+func (kvs *KVStore) Reopen() error {
+	kvs = kvs.root
+	if kvs.borrowed {
+		return ErrBorrowed
+	}
+	// Namespace copies db under nsMu, so hold it while swapping.
+	kvs.nsMu.Lock()
+	kvs.dbMu.Lock()
+	if kvs.closed() {
+		kvs.dbMu.Unlock()
+		kvs.nsMu.Unlock()
+		return ErrClosed
+	}
+	path := kvs.db.Path()
+	err := kvs.db.Close()
+	if err == nil {
+		err = kvs.openDB(path)
+	}
+	if err == nil {
+		kvs.tuneDB()
+		stores := []*KVStore{kvs}
+		for _, ns := range kvs.namespaces {
+			ns.db = kvs.db
+			stores = append(stores, ns)
+		}
+		for _, s := range stores {
+			if s.cache != nil {
+				s.cache.drop("", true)
+			}
+		}
+	}
+	kvs.dbMu.Unlock()
+	kvs.nsMu.Unlock()
+	if err != nil {
+		kvs.Close()
+	}
+	return err
+}
+
+// Open a Key-Value Store in a new temporary directory, which Close removes.
+// Meant for tests. Bolt needs a real file to mmap, so this still touches
+// disk, but the caller never has to manage the path.
+func OpenTemp(opts ...Option) (*KVStore, error) {
+	dir, err := ioutil.TempDir("", "kvs")
+	if err != nil {
+		return nil, err
+	}
+	kvs, err := Open(filepath.Join(dir, "kvs.db"), opts...)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	kvs.tempDir = dir
+	return kvs, nil
+}
+
+// Return the path of the database file, as Bolt opened it. Namespaces
+// and stores from New report the file they share.
+func (kvs *KVStore) Path() string {
+	return kvs.currentDB().Path()
+}
+
+// Flush everything committed so far to disk. Only needed when the store
+// was opened WithNoSync; otherwise every commit is already durable.
+func (kvs *KVStore) Sync() error {
+	if err := kvs.lockDB(); err != nil {
+		return err
+	}
+	defer kvs.root.dbMu.RUnlock()
+	return kvs.db.Sync()
+}
+
+// Report whether every commit is fsynced before it returns, as set by
+// WithSyncWrites. For a store from New this is the *bolt.DB's own NoSync,
+// inverted.
+func (kvs *KVStore) SyncWrites() bool {
+	return !kvs.currentDB().NoSync
+}
+
+// Close the store. Closing a namespace handle does nothing; closing the
+// store it came from closes them all. A store from New leaves its
+// database open. Once Close has returned, every other method of the store
+// and its namespaces fails with ErrClosed. Closing more than once is fine,
+// and the later calls return nil.
 func (kvs *KVStore) Close() error {
-	return kvs.db.Close()
+	if kvs.shared || !atomic.CompareAndSwapInt32(&kvs.isClosed, 0, 1) {
+		return nil
+	}
+	if kvs.stopSweep != nil {
+		kvs.stopSweep()
+	}
+	// Let calls in flight finish, those waiting in db.Batch included.
+	kvs.dbMu.Lock()
+	defer kvs.dbMu.Unlock()
+	if kvs.borrowed {
+		return nil
+	}
+	err := kvs.db.Close()
+	if kvs.tempDir != "" {
+		if rmErr := os.RemoveAll(kvs.tempDir); err == nil {
+			err = rmErr
+		}
+	}
+	return err
 }
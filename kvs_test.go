@@ -0,0 +1,17 @@
+package kvs
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// Open a store in a fresh temporary directory, closed when the test ends.
+func openTest(t *testing.T, opts ...Option) *KVStore {
+	t.Helper()
+	kvs, err := Open(filepath.Join(t.TempDir(), "kvs.db"), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { kvs.Close() })
+	return kvs
+}
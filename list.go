@@ -0,0 +1,47 @@
+package kvs
+
+import bolt "go.etcd.io/bbolt"
+
+// Lists are entries holding a []interface{}, so items of any type can be
+// mixed. Read a whole list with Get into a []interface{}. With the default
+// gob Codec, items of struct and other named types must be passed to
+// Register first, as for any interface value.
+
+// Append item to the list at key in one transaction, starting a new list
+// if key is missing. An entry that isn't a list returns a *DecodeError and
+// is left alone. An existing TTL on key is kept.
+func (kvs *KVStore) Append(key string, item interface{}) (err error) {
+	defer func() { kvs.logOp("Append", key, err) }()
+	if item == nil {
+		return ErrBadValue
+	}
+	return kvs.update(func(tx *bolt.Tx) error {
+		var list []interface{}
+		live := true
+		switch err := kvs.get(tx, key, &list); err {
+		case nil:
+		case ErrNotFound, errExpired:
+			live = false
+		default:
+			return err
+		}
+		data, err := kvs.encode(key, append(list, item))
+		if err != nil {
+			return err
+		}
+		if live {
+			return kvs.write(tx, key, data)
+		}
+		return kvs.put(tx, key, data)
+	})
+}
+
+// Return the number of items in the list at key. The whole list is
+// decoded to count it. Fails like Get.
+func (kvs *KVStore) Len(key string) (int, error) {
+	var list []interface{}
+	if err := kvs.Get(key, &list); err != nil {
+		return 0, err
+	}
+	return len(list), nil
+}
@@ -0,0 +1,87 @@
+package kvs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var ErrNotLocked = errors.New("kvs: lock not held")
+
+// Take the lock called name, held for at most ttl, if nobody holds it. The
+// lock is an ordinary entry at key name, stored raw as a random owner token
+// with ttl as its TTL, so a holder that dies without unlocking loses it
+// once ttl has passed and the next caller takes it over. Reports false,
+// with a nil unlock, when the lock is held by someone else; callers wanting
+// to wait should retry. unlock deletes the entry only if it still holds
+// this call's token, and otherwise returns ErrNotLocked: the lock expired
+// and may belong to someone else by now. ttl must be positive.
+//
+// Any process sharing the file, and any goroutine sharing the store, can
+// contend for the lock. It is only as good as ttl is long: work that can
+// outlast ttl needs to take care.
+func (kvs *KVStore) Lock(name string, ttl time.Duration) (unlock func() error, acquired bool, err error) {
+	defer func() { kvs.logOp("Lock", name, err) }()
+	if ttl <= 0 {
+		return nil, false, ErrBadTTL
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, false, err
+	}
+	token := []byte(hex.EncodeToString(b))
+	err = kvs.update(func(tx *bolt.Tx) error {
+		if kvs.has(tx, name) {
+			acquired = false
+			return nil
+		}
+		acquired = true
+		if err := kvs.write(tx, name, token); err != nil {
+			return err
+		}
+		return kvs.setExpiry(tx, name, encodeExpiry(time.Now().Add(ttl)))
+	})
+	if err != nil || !acquired {
+		return nil, false, err
+	}
+	unlock = func() (err error) {
+		defer func() { kvs.logOp("Unlock", name, err) }()
+		return kvs.update(func(tx *bolt.Tx) error {
+			if v, err := kvs.lookup(tx, name); err != nil || !bytes.Equal(v, token) {
+				return ErrNotLocked
+			}
+			_, err := kvs.delete(tx, name)
+			return err
+		})
+	}
+	return unlock, true, nil
+}
+
+// Run fn holding the store's own lock exclusively, so that no other
+// WithLock or WithRLock call on the store, or on any namespace of it, runs
+// at the same time. This is for multi-step logic spanning several
+// transactions, keeping invariants across keys that a single Update would
+// be too awkward for. It only keeps out other callers of WithLock and
+// WithRLock: plain Puts, Gets and the rest, other processes, and anyone
+// using the *bolt.DB directly, go on regardless. The lock isn't reentrant,
+// so fn must not call WithLock or WithRLock itself. fn's error is
+// returned.
+func (kvs *KVStore) WithLock(fn func() error) error {
+	kvs.root.appMu.Lock()
+	defer kvs.root.appMu.Unlock()
+	return fn()
+}
+
+// Like WithLock, but sharing the lock with other WithRLock calls, for
+// read-mostly sections that only need to keep WithLock out. fn must not
+// call WithLock, and shouldn't call WithRLock either, which can deadlock
+// once a WithLock is waiting.
+func (kvs *KVStore) WithRLock(fn func() error) error {
+	kvs.root.appMu.RLock()
+	defer kvs.root.appMu.RUnlock()
+	return fn()
+}
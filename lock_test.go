@@ -0,0 +1,31 @@
+package kvs
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestWithLockIsSharedByNamespaces(t *testing.T) {
+	kvs := openTest(t)
+	ns := kvs.Namespace("ns")
+	n := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			kvs.WithLock(func() error { n++; return nil })
+		}()
+		go func() {
+			defer wg.Done()
+			ns.WithLock(func() error { n++; return nil })
+		}()
+	}
+	wg.Wait()
+	ns.WithRLock(func() error {
+		if n != 100 {
+			t.Errorf("n = %d, want 100", n)
+		}
+		return nil
+	})
+}
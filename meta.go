@@ -0,0 +1,48 @@
+package kvs
+
+import bolt "go.etcd.io/bbolt"
+
+// The store's own bookkeeping lives in a sibling bucket named after the
+// store's with this suffix, so it never mixes with user keys.
+const metaSuffix = ".meta"
+
+// Return the bookkeeping value stored under name within tx, or nil if
+// there is none. Files written before the meta bucket existed don't have
+// one until opened for writing.
+func (kvs *KVStore) getMeta(tx *bolt.Tx, name string) []byte {
+	if b := tx.Bucket(kvs.metaBucket); b != nil {
+		return b.Get([]byte(name))
+	}
+	return nil
+}
+
+// Store a bookkeeping value under name within tx.
+func (kvs *KVStore) putMeta(tx *bolt.Tx, name string, value []byte) error {
+	return tx.Bucket(kvs.metaBucket).Put([]byte(name), value)
+}
+
+// Return a copy of the store's plain bookkeeping values, such as its
+// schema version under "schema", keyed by name, for debugging. They live
+// in their own bucket beside the store's, so Keys, Count, ForEach and the
+// rest never see them, and their formats are internal and may change.
+// Nested bookkeeping buckets, such as those of indexes and the change log,
+// are left out.
+func (kvs *KVStore) Meta() (map[string][]byte, error) {
+	meta := map[string][]byte{}
+	err := kvs.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(kvs.metaBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			if v != nil {
+				meta[string(k)] = append([]byte(nil), v...)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
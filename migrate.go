@@ -0,0 +1,116 @@
+package kvs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var ErrSchemaTooNew = errors.New("kvs: store schema is newer than its migrations")
+
+// A Migration upgrades the store by one schema version; see WithMigrations.
+// It runs inside the write transaction that records the new version, so an
+// error rolls back both.
+type Migration func(tx Tx) error
+
+// Meta key holding the schema version as a big-endian uint32.
+const schemaKey = "schema"
+
+// Return the store's schema version: how many of the migrations given to
+// WithMigrations have been applied. Stores never opened WithMigrations are
+// at version 0.
+func (kvs *KVStore) SchemaVersion() (uint32, error) {
+	var version uint32
+	err := kvs.view(func(tx *bolt.Tx) error {
+		version = kvs.schemaVersion(tx)
+		return nil
+	})
+	return version, err
+}
+
+func (kvs *KVStore) schemaVersion(tx *bolt.Tx) uint32 {
+	if v := kvs.getMeta(tx, schemaKey); len(v) == 4 {
+		return binary.BigEndian.Uint32(v)
+	}
+	return 0
+}
+
+// Run whichever migrations the store hasn't had yet, in order, each in its
+// own transaction.
+func (kvs *KVStore) migrate(migrations []Migration) error {
+	if len(migrations) == 0 {
+		return nil
+	}
+	version, err := kvs.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if int64(version) > int64(len(migrations)) {
+		return ErrSchemaTooNew
+	}
+	for _, m := range migrations[version:] {
+		t := kvs.newTxn(nil)
+		err := kvs.update(func(tx *bolt.Tx) error {
+			t.tx = tx
+			if err := m(t); err != nil {
+				return err
+			}
+			next := make([]byte, 4)
+			binary.BigEndian.PutUint32(next, kvs.schemaVersion(tx)+1)
+			return kvs.putMeta(tx, schemaKey, next)
+		})
+		t.purge()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rewrite every entry in one write transaction. fn gets each key with its
+// stored bytes, in key order, and returns the bytes to store in their
+// place, or nil to delete the entry. old is only valid until fn returns.
+// Entries fn returns unchanged aren't rewritten, and TTLs are kept.
+// Expired entries are skipped. An error from fn rolls the whole migration
+// back and is returned.
+//
+// The transaction holds the write lock throughout, so other writers wait
+// until the migration is done.
+func (kvs *KVStore) Migrate(fn func(key string, old []byte) (new []byte, err error)) (err error) {
+	defer func() { kvs.logOp("Migrate", "", err) }()
+	return kvs.update(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(kvs.bucket).Cursor()
+		expiry, now := tx.Bucket(kvs.expiryBucket), time.Now()
+		for k, v := cursor.First(); k != nil; {
+			if expired(expiry, k, now) {
+				k, v = cursor.Next()
+				continue
+			}
+			key := string(k)
+			data, err := fn(key, v)
+			if err != nil {
+				return err
+			}
+			if data != nil && bytes.Equal(data, v) {
+				k, v = cursor.Next()
+				continue
+			}
+			if data == nil {
+				_, err = kvs.delete(tx, key)
+			} else {
+				err = kvs.write(tx, key, data)
+			}
+			if err != nil {
+				return err
+			}
+			// Writing moves the cursor, so find our place again.
+			if k, v = cursor.Seek([]byte(key)); k != nil && string(k) == key {
+				k, v = cursor.Next()
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,40 @@
+package kvs
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMigrationForEachUnderReadTimeout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kvs.db")
+	kvs, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := make(map[string]interface{})
+	for i := 0; i < 600; i++ {
+		entries[fmt.Sprintf("k%03d", i)] = i
+	}
+	if err := kvs.PutAll(entries); err != nil {
+		t.Fatal(err)
+	}
+	kvs.Close()
+
+	visited := 0
+	migration := func(tx Tx) error {
+		return tx.ForEach(func(key string, raw []byte) error {
+			visited++
+			return nil
+		})
+	}
+	kvs, err = Open(path, WithReadTimeout(time.Hour), WithMigrations([]Migration{migration}))
+	if err != nil {
+		t.Fatalf("Open: %v after visiting %d entries", err, visited)
+	}
+	defer kvs.Close()
+	if visited != 600 {
+		t.Fatalf("visited %d entries, want 600", visited)
+	}
+}
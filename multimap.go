@@ -0,0 +1,124 @@
+package kvs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// A multimap key holds any number of values, each stored as its own entry
+// under the key, a zero byte, then an 8-byte big-endian sequence number, so
+// that a key's values sort together in the order they were added. Those
+// entries are ordinary keys as far as Keys and ForEach are concerned, and
+// keys used with AddTo shouldn't themselves contain zero bytes. Values are
+// stored as interfaces, so with the default gob Codec their concrete types
+// must be passed to Register, as for GetValue.
+
+// Return the prefix shared by every value of the multimap key.
+func multiPrefix(key string) []byte {
+	return append([]byte(key), 0)
+}
+
+// Add value to the values held by key, in one transaction.
+func (kvs *KVStore) AddTo(key string, value interface{}) (err error) {
+	defer func() { kvs.logOp("AddTo", key, err) }()
+	if value == nil {
+		return ErrBadValue
+	}
+	// The validator sees the key as given, not the entry key made from it.
+	if err := kvs.validateKey(key); err != nil {
+		return err
+	}
+	if err := kvs.validate(key, value); err != nil {
+		return err
+	}
+	data, err := kvs.marshal(&value)
+	if err != nil {
+		return err
+	}
+	return kvs.update(func(tx *bolt.Tx) error {
+		seq, err := tx.Bucket(kvs.bucket).NextSequence()
+		if err != nil {
+			return err
+		}
+		sub := make([]byte, 8)
+		binary.BigEndian.PutUint64(sub, seq)
+		return kvs.putEntry(tx, string(append(multiPrefix(key), sub...)), data)
+	})
+}
+
+// Return every value held by key, in the order they were added, from one
+// read transaction. Returns ErrNotFound if key holds none.
+func (kvs *KVStore) GetAll(key string) ([]interface{}, error) {
+	var values []interface{}
+	err := kvs.view(func(tx *bolt.Tx) error {
+		values = nil
+		return kvs.eachOf(tx, key, func(k, v []byte) error {
+			var value interface{}
+			if err := kvs.decode(string(k), v, &value); err != nil {
+				return err
+			}
+			values = append(values, value)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, ErrNotFound
+	}
+	return values, nil
+}
+
+// Remove every copy of value from the values held by key, comparing with
+// reflect.DeepEqual, in one transaction. Returns ErrNotFound if key didn't
+// hold value.
+func (kvs *KVStore) RemoveFrom(key string, value interface{}) (err error) {
+	defer func() { kvs.logOp("RemoveFrom", key, err) }()
+	return kvs.update(func(tx *bolt.Tx) error {
+		var matched []string
+		err := kvs.eachOf(tx, key, func(k, v []byte) error {
+			var stored interface{}
+			if err := kvs.decode(string(k), v, &stored); err != nil {
+				return err
+			}
+			if reflect.DeepEqual(stored, value) {
+				matched = append(matched, string(k))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if len(matched) == 0 {
+			return ErrNotFound
+		}
+		for _, k := range matched {
+			if _, err := kvs.delete(tx, k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Call fn with each unexpired entry holding a value of the multimap key
+// within tx.
+func (kvs *KVStore) eachOf(tx *bolt.Tx, key string, fn func(k, v []byte) error) error {
+	cursor := tx.Bucket(kvs.bucket).Cursor()
+	expiry, now := tx.Bucket(kvs.expiryBucket), time.Now()
+	p := multiPrefix(key)
+	for k, v := cursor.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = cursor.Next() {
+		if len(k) != len(p)+8 || expired(expiry, k, now) {
+			continue
+		}
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
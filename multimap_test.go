@@ -0,0 +1,29 @@
+package kvs
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestAddToValidatesTheKeyAsGiven(t *testing.T) {
+	errNul := errors.New("nul in key")
+	kvs := openTest(t, WithKeyValidator(func(key string) error {
+		if strings.Contains(key, "\x00") {
+			return errNul
+		}
+		return nil
+	}))
+	if err := kvs.AddTo("tags", "a"); err != nil {
+		t.Fatalf("AddTo: %v", err)
+	}
+	if values, err := kvs.GetAll("tags"); err != nil || len(values) != 1 {
+		t.Fatalf("GetAll = %v, %v", values, err)
+	}
+	if err := kvs.AddTo("bad\x00", "a"); err != errNul {
+		t.Errorf("AddTo of a bad key: %v", err)
+	}
+	if err := kvs.AddTo("", "a"); err != ErrBadKey {
+		t.Errorf("AddTo of the empty key: %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+package kvs
+
+// Return a handle on a separate set of keys called name, kept in its own
+// bucket in the same file and sharing the store's transactions, options
+// and Codec. The bucket is created the first time the handle is used.
+// Asking for the same name again returns the same handle, and asking for
+// the store's own bucket name returns the store itself. Names ending in
+// ".ttl" or ".meta" are reserved; using a handle with such a name fails
+// with ErrBadBucket.
+//
+// Closing a namespace handle does nothing. Closing the store it came from
+// closes the file underneath every namespace.
+func (kvs *KVStore) Namespace(name string) *KVStore {
+	root := kvs.root
+	if name == string(root.bucket) {
+		return root
+	}
+	root.nsMu.Lock()
+	defer root.nsMu.Unlock()
+	if ns, ok := root.namespaces[name]; ok {
+		return ns
+	}
+	ns := &KVStore{
+		db:           root.db,
+		bucket:       []byte(name),
+		expiryBucket: []byte(name + expirySuffix),
+		metaBucket:   []byte(name + metaSuffix),
+		codec:        root.codec,
+		watch:        &watchers{},
+		cache:        newReadCache(root.opts.readCache),
+		readOnly:     root.readOnly,
+		opts:         root.opts,
+		root:         root,
+		shared:       true,
+	}
+	if root.namespaces == nil {
+		root.namespaces = make(map[string]*KVStore)
+	}
+	root.namespaces[name] = ns
+	return ns
+}
+
+// A Bucket is a namespace used to keep one kind of value apart from the
+// rest, with the whole KVStore surface: Put, Get, Delete, ForEach, and a
+// Clear and Count of its own.
+type Bucket struct {
+	*KVStore
+}
+
+// Return a handle on the bucket called name, created the first time it is
+// used. It is Namespace by another name, and the same rules apply: names
+// ending in ".ttl" or ".meta" are reserved, and closing the store closes
+// every bucket.
+func (kvs *KVStore) Bucket(name string) *Bucket {
+	return &Bucket{kvs.Namespace(name)}
+}
@@ -0,0 +1,21 @@
+package kvs
+
+import "time"
+
+// An Observer hears about every Get, Put and Delete on a store opened
+// WithObserver, including the Context variants, once each has finished and
+// its transaction is closed. err is whatever the call returned. Methods may
+// be called from many goroutines at once, and slow ones slow the caller
+// down, so they should only record what they need and return.
+type Observer interface {
+	ObserveGet(key string, d time.Duration, err error)
+	ObservePut(key string, d time.Duration, err error)
+	ObserveDelete(key string, d time.Duration, err error)
+}
+
+// Pass a finished mutation to the WithLogger callback, if there is one.
+func (kvs *KVStore) logOp(op, key string, err error) {
+	if fn := kvs.opts.logger; fn != nil {
+		fn(op, key, err)
+	}
+}
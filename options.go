@@ -0,0 +1,399 @@
+package kvs
+
+import (
+	"os"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// An Option configures a Key-Value Store when it is opened.
+type Option func(*options)
+
+type options struct {
+	bucket   string
+	timeout  time.Duration
+	fileMode os.FileMode
+	mkdirAll bool
+	dirMode  os.FileMode
+	readOnly bool
+	noSync   bool
+	codec    Codec
+	// How often to sweep expired entries; zero means never.
+	sweepInterval time.Duration
+	observer      Observer
+	logger        func(op, key string, err error)
+	migrations    []Migration
+	// Longest ForEach and friends may run; zero means no limit.
+	readTimeout time.Duration
+	// Bolt's MaxBatchSize and MaxBatchDelay; zero keeps Bolt's default.
+	flushCount    int
+	flushInterval time.Duration
+	// Largest value in bytes, as stored; zero means no limit.
+	maxValueSize int
+	// Entries in each store's read cache; zero means no cache.
+	readCache int
+	// AES key for WithEncryption.
+	encryptionKey []byte
+	compress      bool
+	checksum      bool
+	// Smallest encoded value WithCompression compresses.
+	compressMin int
+	// Passed through to bolt.Options.
+	initialMmapSize int
+	pageSize        int
+	noFreelistSync  bool
+	freelistType    bolt.FreelistType
+	// Free page ratio above which Open compacts; zero means never.
+	autoCompact float64
+	// Set WithChangeLog.
+	changeLog    bool
+	validator    func(key string, value interface{}) error
+	keyValidator func(key string) error
+	// Set WithBackingStore.
+	loader func(key string) ([]byte, error)
+	// TTL for entries written without one; zero means never expire.
+	defaultTTL time.Duration
+}
+
+// Wrap the configured Codec with any value transforms. The checksum is of
+// the encoded value itself, and compression has to come before encryption,
+// since ciphertext doesn't compress.
+func (o *options) buildCodec() (Codec, error) {
+	codec := o.codec
+	if o.checksum {
+		codec = checksummingCodec{Codec: codec}
+	}
+	if o.compress {
+		codec = compressingCodec{Codec: codec, threshold: o.compressMin}
+	}
+	if o.encryptionKey != nil {
+		var err error
+		if codec, err = newEncryptingCodec(codec, o.encryptionKey); err != nil {
+			return nil, err
+		}
+	}
+	return codec, nil
+}
+
+// Keep entries in the named Bolt bucket instead of the default "kvs".
+// Stores using different buckets can share one file without seeing each
+// other's keys. Names ending in ".ttl" or ".meta" are reserved.
+func WithBucket(name string) Option {
+	return func(o *options) {
+		o.bucket = name
+	}
+}
+
+// How long Open waits for the file lock held by another process.
+// Defaults to 50ms. Zero waits forever.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.timeout = d
+	}
+}
+
+// Permissions used when the file is created. Defaults to 0640.
+func WithFileMode(mode os.FileMode) Option {
+	return func(o *options) {
+		o.fileMode = mode
+	}
+}
+
+// Create any missing directories leading to the path given to Open, with
+// permissions perm, as os.MkdirAll does. Without it Open fails if they
+// don't exist.
+func WithMkdirAll(perm os.FileMode) Option {
+	return func(o *options) {
+		o.mkdirAll = true
+		o.dirMode = perm
+	}
+}
+
+// Open the file read-only, taking a shared lock so several readers can
+// open it at once. Bolt's lock still excludes writers: while any reader
+// has the file open, a writer's Open waits, and the other way round. The
+// bucket must already exist, otherwise Open returns ErrNoBucket. Every
+// write fails with ErrReadOnly before a transaction is started, and
+// expired entries are hidden but not deleted.
+func WithReadOnly(readOnly bool) Option {
+	return func(o *options) {
+		o.readOnly = readOnly
+	}
+}
+
+// Encode values with c instead of gob. Files must always be opened with
+// the same Codec they were written with.
+func WithCodec(c Codec) Option {
+	return func(o *options) {
+		o.codec = c
+	}
+}
+
+// Encrypt every value with AES-GCM under key, which must be 16, 24 or 32
+// bytes long. Keys are still stored in plaintext. Values that fail to
+// decrypt, because of a wrong key or tampering, return ErrDecrypt.
+func WithEncryption(key []byte) Option {
+	return func(o *options) {
+		o.encryptionKey = key
+	}
+}
+
+// Gzip encoded values of at least threshold bytes. Smaller values aren't
+// worth the CPU and are stored as they are, as are values that gzip
+// wouldn't shrink. Values written before compression was enabled still
+// read normally, provided the Codec never produces output starting with a
+// zero byte; gob and JSON never do.
+func WithCompression(threshold int) Option {
+	return func(o *options) {
+		o.compress = true
+		o.compressMin = threshold
+	}
+}
+
+// Store a CRC-32C checksum with every encoded value and verify it on each
+// read, returning ErrChecksumMismatch if the bytes have changed. This
+// catches corruption that Bolt's own page checks miss. Values written
+// without a checksum still read normally, on the same terms as
+// WithCompression. Compressed and encrypted values are checked again by
+// gzip and AES-GCM.
+func WithChecksum() Option {
+	return func(o *options) {
+		o.checksum = true
+	}
+}
+
+// Skip the fsync after each commit. Writes get much faster, but anything
+// committed since the last Sync can be lost, or the file corrupted, if the
+// machine crashes or loses power. Meant for bulk loads that call Sync at
+// the end and can be redone from scratch if interrupted.
+func WithNoSync(noSync bool) Option {
+	return func(o *options) {
+		o.noSync = noSync
+	}
+}
+
+// Number every write and delete with an increasing sequence number, so
+// Since can list what changed after a given point, for incremental sync to
+// another system. Each change costs a couple of extra bookkeeping writes in
+// the same transaction.
+func WithChangeLog() Option {
+	return func(o *options) {
+		o.changeLog = true
+	}
+}
+
+// Check every value before it is stored. fn gets the key and the value
+// exactly as passed to Put, PutWithTTL, PutAll, Txn.Put and the other
+// writers, before it is encoded, and a non-nil error rejects the write and
+// is returned from it unchanged. A multi-key write such as PutAll is
+// rejected as a whole if any of its values is. Increment passes the new
+// int64 and Append the whole new list. Bytes stored as they are, by PutRaw,
+// PutString, CopyTo, Rename or ImportJSON's raw entries, aren't checked.
+// fn may run inside a write transaction, so it should be quick and must
+// not use the store.
+func WithValueValidator(fn func(key string, value interface{}) error) Option {
+	return func(o *options) {
+		o.validator = fn
+	}
+}
+
+// Check every key before anything is stored at it. fn gets the key of each
+// entry written, by Put, PutAll, PutRaw, Rename, CopyTo, ImportJSON and
+// every other writer, and a non-nil error rejects the write and is
+// returned from it unchanged; as with WithValueValidator, a multi-key
+// write is rejected as a whole. AddTo's key is checked as given, not the
+// entry keys it makes from it. The empty key is always rejected, with
+// ErrBadKey, before fn is called. Deletes and reads aren't checked, so
+// keys written before fn was set can still be read and cleaned up. fn runs
+// inside the write transaction, so it should be quick and must not use
+// the store.
+func WithKeyValidator(fn func(key string) error) Option {
+	return func(o *options) {
+		o.keyValidator = fn
+	}
+}
+
+// Choose whether each commit is fsynced before the write that made it
+// returns. The default, true, is Bolt's own: once Put and the other
+// writers return without error, the change survives a crash or power
+// loss. False is WithNoSync(true), and durability then only extends to
+// the last explicit Sync. Whichever is given last of the two wins.
+func WithSyncWrites(sync bool) Option {
+	return WithNoSync(!sync)
+}
+
+// Map at least n bytes of the file from the start. A writer that has to
+// grow the file past the mapped size waits for every open read
+// transaction to finish, so a size the file won't outgrow keeps long
+// reads, Iterators among them, from ever stalling writes. It mainly pays
+// off when a store is known to get big, such as ahead of a large import,
+// which otherwise remaps again and again as it goes, blocking readers each
+// time. Only address space is reserved; the file itself doesn't grow until
+// it fills up. Stores from New use whatever their *bolt.DB was opened with.
+func WithInitialMmapSize(n int) Option {
+	return func(o *options) {
+		o.initialMmapSize = n
+	}
+}
+
+// Use pages of n bytes instead of the OS page size. Bolt only reads this
+// when it creates the file; an existing file keeps the page size it was
+// created with, whatever is given here. Bigger pages suit large values,
+// which otherwise spill onto overflow pages.
+func WithPageSize(n int) Option {
+	return func(o *options) {
+		o.pageSize = n
+	}
+}
+
+// Don't write the freelist to disk on each commit. Commits get cheaper,
+// most of all for big files with many free pages, but each Open has to
+// rebuild the freelist by scanning the whole file.
+func WithNoFreelistSync(noFreelistSync bool) Option {
+	return func(o *options) {
+		o.noFreelistSync = noFreelistSync
+	}
+}
+
+// Pick how Bolt keeps the freelist in memory: bolt.FreelistArrayType, the
+// default, or bolt.FreelistMapType, which allocates faster once a file has
+// a lot of free pages. It only affects the open store, not the file.
+func WithFreelistType(t bolt.FreelistType) Option {
+	return func(o *options) {
+		o.freelistType = t
+	}
+}
+
+// Compact the file on Open, before returning the store, when more than
+// ratio of its pages are free, as with Compact into a temporary file in
+// the same directory that then replaces the original. Churn-heavy stores
+// thereby give space back at each restart without anyone scheduling it.
+// While it runs, Open takes as long as copying the whole file, and needs
+// the disk space for a second copy. When it happens, the WithLogger
+// callback sees an "AutoCompact" op with an empty key. Zero, the default,
+// never compacts, and read-only stores and those from New are left alone.
+func WithAutoCompact(ratio float64) Option {
+	return func(o *options) {
+		o.autoCompact = ratio
+	}
+}
+
+// Delete expired entries in the background every d, instead of only when
+// they are next read. The sweep covers the store and every namespace
+// opened from it and stops when the store is closed. Disabled by default,
+// and ignored for read-only stores.
+func WithSweepInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.sweepInterval = d
+	}
+}
+
+// Report every Get, Put and Delete to obs once it finishes.
+func WithObserver(obs Observer) Option {
+	return func(o *options) {
+		o.observer = obs
+	}
+}
+
+// Call fn after every operation that changes the store, with the method
+// name as op, the key it touched and the error it returned. Batch calls
+// such as PutAll and DeleteAll report each key; calls without a single key,
+// like Clear or Update, pass "". Expired entries removed in the background
+// aren't reported.
+func WithLogger(fn func(op, key string, err error)) Option {
+	return func(o *options) {
+		o.logger = fn
+	}
+}
+
+// Upgrade the store's data when it is opened. migrations[i] takes the
+// store from schema version i to i+1, so appending a Migration is how a
+// new version ships. Each pending one runs in its own write transaction,
+// which also records the new version; if one fails, Open returns its error
+// and the store stays at the last version that succeeded. A new store
+// starts at version 0 and runs them all. Open fails with ErrSchemaTooNew
+// if the store is already past len(migrations), and with ErrReadOnly if it
+// is behind but opened WithReadOnly. Namespaces aren't migrated.
+func WithMigrations(migrations []Migration) Option {
+	return func(o *options) {
+		o.migrations = migrations
+	}
+}
+
+// Stop ForEach, Range and the other iterations that call back into the
+// caller with ErrTimeout once they have run for longer than d. A slow
+// callback holds the read transaction open, which can stall a writer that
+// needs to grow the file. The clock is checked every few hundred entries,
+// so an iteration can overrun d by as long as that many callbacks take.
+// Zero, the default, means no limit.
+func WithReadTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.readTimeout = d
+	}
+}
+
+// Refuse to store values larger than n bytes, returning ErrValueTooLarge
+// instead. The limit applies to the bytes as stored, after encoding,
+// compression and encryption, and to raw values too. Zero, the default,
+// means no limit.
+func WithMaxValueSize(n int) Option {
+	return func(o *options) {
+		o.maxValueSize = n
+	}
+}
+
+// Tune how PutBatch coalesces writes: a shared transaction commits once
+// count writes have joined it or interval has passed since the first,
+// whichever comes sooner. Bigger values trade latency for throughput.
+// Zero leaves Bolt's defaults of 1000 writes and 10ms. Every PutBatch call
+// still waits for its own commit, and Close waits for any still pending,
+// so nothing accepted is lost.
+func WithAutoFlush(count int, interval time.Duration) Option {
+	return func(o *options) {
+		o.flushCount = count
+		o.flushInterval = interval
+	}
+}
+
+// Keep the stored bytes of up to n recently read keys in memory, so that
+// Get and GetRaw of a cached key skip the Bolt transaction; Get still
+// decodes on every call. Writes through the store drop the keys they
+// change. Each namespace gets a cache of its own. Writes made to the
+// bucket behind the store's back, through the *bolt.DB given to New, are
+// not seen. CacheStats reports how well it is doing. Zero, the default,
+// means no cache.
+func WithReadCache(n int) Option {
+	return func(o *options) {
+		o.readCache = n
+	}
+}
+
+// Put the store in front of a slower one: when Get or GetValue finds no
+// live entry at key, it calls loader, keeps what comes back with PutRaw,
+// and decodes it. loader returns the bytes as they would be stored, in the
+// store's Codec and with any compression, checksum or encryption applied,
+// and (nil, nil) or an error matching ErrNotFound when the backing store
+// doesn't have key either, which Get reports as ErrNotFound. Its other
+// errors are returned from Get as they are. A read-only store decodes what
+// loader returns without keeping it. Other reads, such as GetRaw, Has and
+// ForEach, only see what has been loaded already.
+func WithBackingStore(loader func(key string) ([]byte, error)) Option {
+	return func(o *options) {
+		o.loader = loader
+	}
+}
+
+// Give every entry written without a TTL of its own, by Put, PutAll,
+// Txn.Put and the rest, one of d, as if written with PutWithTTL. Pass
+// NoExpiry to PutWithTTL for an entry that should never expire, or another
+// TTL to override d. Writes that keep an entry's expiry, like Increment
+// and Append on a live key, still keep it, and entries moved or copied as
+// they are, by Rename, CopyTo and ImportJSON, keep whatever expiry they
+// had, including none. Default expiries are swept like any other. Zero,
+// the default, means entries never expire unless told to.
+func WithDefaultTTL(d time.Duration) Option {
+	return func(o *options) {
+		o.defaultTTL = d
+	}
+}
@@ -0,0 +1,46 @@
+package kvs
+
+import (
+	"path/filepath"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestPageSizeOnlyAppliesOnCreate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kvs.db")
+	kvs, err := Open(path, WithPageSize(8192))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := kvs.db.Info().PageSize; got != 8192 {
+		t.Fatalf("page size %d, want 8192", got)
+	}
+	if err := kvs.Put("a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	kvs.Close()
+
+	kvs, err = Open(path, WithPageSize(16384))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kvs.Close()
+	if got := kvs.db.Info().PageSize; got != 8192 {
+		t.Fatalf("reopened page size %d, want 8192", got)
+	}
+}
+
+func TestFreelistOptions(t *testing.T) {
+	kvs := openTest(t, WithNoFreelistSync(true), WithFreelistType(bolt.FreelistMapType))
+	if !kvs.db.NoFreelistSync || kvs.db.FreelistType != bolt.FreelistMapType {
+		t.Fatalf("freelist options not passed to bolt: %v %q", kvs.db.NoFreelistSync, kvs.db.FreelistType)
+	}
+	if err := kvs.Put("a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	var v string
+	if err := kvs.Get("a", &v); err != nil || v != "1" {
+		t.Fatalf("Get = %q, %v", v, err)
+	}
+}
@@ -0,0 +1,123 @@
+package kvs
+
+import (
+	bolt "go.etcd.io/bbolt"
+)
+
+// Returned by AtomicPut when the second store's writes failed after the
+// first store's had committed, and undoing those failed too, so the first
+// store has kept them. Err is the second store's error, which errors.Is and
+// errors.As see through, and UndoErr the one from undoing the first.
+type PartialPutError struct {
+	Err     error
+	UndoErr error
+}
+
+func (e *PartialPutError) Error() string {
+	return "kvs: AtomicPut wrote the first store only: " + e.Err.Error() +
+		"; undoing it failed: " + e.UndoErr.Error()
+}
+
+func (e *PartialPutError) Unwrap() error {
+	return e.Err
+}
+
+// What a key held before AtomicPut wrote it, to put back if need be.
+type undoEntry struct {
+	key string
+	// The stored bytes and expiry; data is nil if the key was absent.
+	data, expiry []byte
+}
+
+// Put the entries of aEntries in a and those of bEntries in b, so that
+// either both sets are written or, as far as possible, neither is. Every
+// value is encoded before anything is written, so a bad value writes
+// nothing. As with Put, any TTL on the keys is replaced.
+//
+// When a and b share a database file, as namespaces of one store do, both
+// sets go in one transaction and the guarantee is Bolt's own. Otherwise
+// this is best effort, not a true two-phase commit: a's transaction
+// commits first, then b's, and if b's fails a's keys are put back the way
+// they were in a second transaction of a. In between, readers of a can see
+// its new entries, and the undo overwrites any changes other writers made
+// to those keys meanwhile. A crash between the two commits leaves a
+// written and b not. If the undo itself fails, the error is a
+// *PartialPutError; any other error means neither store was changed.
+func AtomicPut(a, b *KVStore, aEntries, bEntries map[string]interface{}) (err error) {
+	defer func() {
+		for key := range aEntries {
+			a.logOp("AtomicPut", key, err)
+		}
+		for key := range bEntries {
+			b.logOp("AtomicPut", key, err)
+		}
+	}()
+	aData, err := a.encodeAll(aEntries)
+	if err != nil {
+		return err
+	}
+	bData, err := b.encodeAll(bEntries)
+	if err != nil {
+		return err
+	}
+	if a.readOnly || b.readOnly {
+		return ErrReadOnly
+	}
+	if err := b.prepare(); err != nil {
+		return err
+	}
+	if a.currentDB() == b.currentDB() {
+		return a.update(func(tx *bolt.Tx) error {
+			if err := a.putAll(tx, aData); err != nil {
+				return err
+			}
+			return b.putAll(tx, bData)
+		})
+	}
+	var undo []undoEntry
+	err = a.update(func(tx *bolt.Tx) error {
+		undo = undo[:0]
+		data, expiry := tx.Bucket(a.bucket), tx.Bucket(a.expiryBucket)
+		for key := range aData {
+			e := undoEntry{key: key}
+			if v := data.Get([]byte(key)); v != nil {
+				e.data = append([]byte(nil), v...)
+				if t := expiry.Get([]byte(key)); t != nil {
+					e.expiry = append([]byte(nil), t...)
+				}
+			}
+			undo = append(undo, e)
+		}
+		return a.putAll(tx, aData)
+	})
+	if err != nil {
+		return err
+	}
+	err = b.update(func(tx *bolt.Tx) error {
+		return b.putAll(tx, bData)
+	})
+	if err == nil {
+		return nil
+	}
+	undoErr := a.update(func(tx *bolt.Tx) error {
+		for _, e := range undo {
+			if e.data == nil {
+				if _, err := a.delete(tx, e.key); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := a.write(tx, e.key, e.data); err != nil {
+				return err
+			}
+			if err := a.setExpiry(tx, e.key, e.expiry); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if undoErr != nil {
+		return &PartialPutError{Err: err, UndoErr: undoErr}
+	}
+	return err
+}
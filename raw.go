@@ -0,0 +1,116 @@
+package kvs
+
+import bolt "go.etcd.io/bbolt"
+
+// Puts bytes into the Key-Value Store exactly as given, bypassing the Codec.
+// Use GetRaw to read them back; Get can't decode them. Nil data is not
+// allowed (empty slices valid).
+// This is the fast path for blobs: Put always frames values with the Codec,
+// even []byte, because raw and encoded entries can't be told apart on disk.
+func (kvs *KVStore) PutRaw(key string, data []byte) (err error) {
+	defer func() { kvs.logOp("PutRaw", key, err) }()
+	if data == nil {
+		return ErrBadValue
+	}
+	return kvs.update(func(tx *bolt.Tx) error {
+		return kvs.put(tx, key, data)
+	})
+}
+
+// Return the bytes stored at key without decoding them.
+// The result is a copy and stays valid after the call.
+// No matching values returns ErrNotFound
+func (kvs *KVStore) GetRaw(key string) ([]byte, error) {
+	var data []byte
+	var err error
+	if kvs.cache != nil {
+		if data, err = kvs.cached(key); err == nil {
+			data = append([]byte(nil), data...)
+		}
+	} else {
+		err = kvs.view(func(tx *bolt.Tx) error {
+			v, err := kvs.lookup(tx, key)
+			if err != nil {
+				return err
+			}
+			data = make([]byte, len(v))
+			copy(data, v)
+			return nil
+		})
+	}
+	if err == errExpired {
+		kvs.purge(key)
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+// Call fn with the bytes stored at key, as GetRaw would return them but
+// without the copy: data points straight into Bolt's memory map and is
+// only valid until fn returns, so fn must not keep it, or any slice of it,
+// and must not modify it. fn runs inside a read transaction, which holds
+// off a writer that needs to grow the file, so it should be quick, and
+// must not write to the store. An error from fn is returned as it is. No
+// matching values returns ErrNotFound without calling fn. The read cache
+// is bypassed.
+func (kvs *KVStore) GetRawZeroCopy(key string, fn func(data []byte) error) error {
+	err := kvs.view(func(tx *bolt.Tx) error {
+		v, err := kvs.lookup(tx, key)
+		if err != nil {
+			return err
+		}
+		return fn(v)
+	})
+	if err == errExpired {
+		kvs.purge(key)
+		return ErrNotFound
+	}
+	return err
+}
+
+// Like Get, but also return a copy of the bytes stored at key, read in the
+// same transaction as the value, for callers that forward the stored form
+// and inspect the decoded one. If they don't decode into value the bytes
+// are still returned, along with the *DecodeError.
+func (kvs *KVStore) GetWithRaw(key string, value interface{}) (raw []byte, err error) {
+	if kvs.cache != nil {
+		if raw, err = kvs.cached(key); err == nil {
+			raw = append([]byte(nil), raw...)
+		}
+	} else {
+		err = kvs.view(func(tx *bolt.Tx) error {
+			v, err := kvs.lookup(tx, key)
+			if err != nil {
+				return err
+			}
+			raw = append([]byte(nil), v...)
+			return nil
+		})
+	}
+	if err == errExpired {
+		kvs.purge(key)
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return raw, kvs.decode(key, raw, value)
+}
+
+// Puts a string into the Key-Value Store as its bytes, bypassing the Codec,
+// which is quicker than Put for plain text. Strings stored this way are
+// raw entries: read them back with GetString or GetRaw, not Get. Encryption
+// and compression don't apply to them.
+func (kvs *KVStore) PutString(key, value string) (err error) {
+	defer func() { kvs.logOp("PutString", key, err) }()
+	return kvs.update(func(tx *bolt.Tx) error {
+		return kvs.put(tx, key, []byte(value))
+	})
+}
+
+// Return the string stored at key by PutString. It returns the raw bytes
+// of whatever is there, so it can't read values stored with Put.
+// No matching values returns ErrNotFound
+func (kvs *KVStore) GetString(key string) (string, error) {
+	data, err := kvs.GetRaw(key)
+	return string(data), err
+}
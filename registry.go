@@ -0,0 +1,81 @@
+package kvs
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+var ErrUnknownPrefix = errors.New("kvs: no type registered for key")
+
+// Returned by GetAuto for a key no prefix registered with RegisterPrefix
+// matches. It matches ErrUnknownPrefix with errors.Is.
+type UnknownPrefixError struct {
+	Key string
+}
+
+func (e *UnknownPrefixError) Error() string {
+	return fmt.Sprintf("kvs: no type registered for key %q", e.Key)
+}
+
+func (e *UnknownPrefixError) Is(target error) bool {
+	return target == ErrUnknownPrefix
+}
+
+// Key prefixes registered with RegisterPrefix, and their types.
+var prefixTypes struct {
+	sync.RWMutex
+	types map[string]reflect.Type
+}
+
+// Register the type of proto for keys starting with prefix, so GetAuto can
+// decode them without the caller saying what they hold: pass a Widget{}
+// and GetAuto returns Widgets, as ForEachTyped would. When several
+// prefixes match a key, the longest wins, so "user:" and "user:admin:" can
+// carry different types. Registering a prefix again replaces its type.
+// The registry is shared by every store in the process; call it, usually
+// from init, before any GetAuto.
+func RegisterPrefix(prefix string, proto interface{}) {
+	if proto == nil {
+		panic("kvs: RegisterPrefix of nil proto")
+	}
+	prefixTypes.Lock()
+	defer prefixTypes.Unlock()
+	if prefixTypes.types == nil {
+		prefixTypes.types = make(map[string]reflect.Type)
+	}
+	prefixTypes.types[prefix] = reflect.TypeOf(proto)
+}
+
+// Return the type registered for the longest prefix of key.
+func prefixType(key string) (reflect.Type, bool) {
+	prefixTypes.RLock()
+	defer prefixTypes.RUnlock()
+	var best reflect.Type
+	n := -1
+	for prefix, t := range prefixTypes.types {
+		if len(prefix) > n && strings.HasPrefix(key, prefix) {
+			best, n = t, len(prefix)
+		}
+	}
+	return best, best != nil
+}
+
+// Return the entry at key decoded into a fresh value of the type
+// registered with RegisterPrefix for the longest prefix of key, for
+// callers that dispatch on what comes back rather than knowing it up
+// front. Returns an *UnknownPrefixError if no registered prefix matches,
+// before reading anything, and fails like Get otherwise.
+func (kvs *KVStore) GetAuto(key string) (interface{}, error) {
+	t, ok := prefixType(key)
+	if !ok {
+		return nil, &UnknownPrefixError{Key: key}
+	}
+	value := reflect.New(t)
+	if err := kvs.Get(key, value.Interface()); err != nil {
+		return nil, err
+	}
+	return value.Elem().Interface(), nil
+}
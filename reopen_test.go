@@ -0,0 +1,73 @@
+package kvs
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestReopenUnderConcurrentUse(t *testing.T) {
+	kvs := openTest(t, WithReadCache(10))
+	ns := kvs.Namespace("ns")
+	if err := kvs.Put("fixed", 1); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if err := ns.Put(fmt.Sprint(g, "/", i), i); err != nil {
+					t.Errorf("Put during Reopen: %v", err)
+					return
+				}
+				var n int
+				if err := kvs.Get("fixed", &n); err != nil || n != 1 {
+					t.Errorf("Get during Reopen = %d, %v", n, err)
+					return
+				}
+			}
+		}(g)
+	}
+	for i := 0; i < 20; i++ {
+		time.Sleep(time.Millisecond)
+		if err := ns.Reopen(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+	if n, err := ns.Count(); err != nil || n == 0 {
+		t.Errorf("namespace after Reopen: %d entries, %v", n, err)
+	}
+}
+
+func TestReopenClosedOrBorrowed(t *testing.T) {
+	kvs := openTest(t)
+	kvs.Close()
+	if err := kvs.Reopen(); err != ErrClosed {
+		t.Errorf("Reopen after Close: %v", err)
+	}
+	db, err := bolt.Open(t.TempDir()+"/bolt.db", 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	borrowed, err := New(db, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := borrowed.Reopen(); err != ErrBorrowed {
+		t.Errorf("Reopen of a store from New: %v", err)
+	}
+}
@@ -0,0 +1,248 @@
+package kvs
+
+import (
+	"bytes"
+	"reflect"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Return up to limit keys that sort after the key after, for paging through
+// the store. next is the token to pass as after for the following page,
+// and is empty once there are no more keys. An empty after starts from the
+// first key. A limit of zero or less returns every remaining key.
+//
+// Because pages resume from a key rather than an offset, writes between
+// calls never cause keys to be skipped or repeated.
+func (kvs *KVStore) Scan(after string, limit int) (keys []string, next string, err error) {
+	keys = []string{}
+	err = kvs.view(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(kvs.bucket).Cursor()
+		expiry, now := tx.Bucket(kvs.expiryBucket), time.Now()
+		k, _ := cursor.Seek([]byte(after))
+		if k != nil && after != "" && string(k) == after {
+			k, _ = cursor.Next()
+		}
+		for ; k != nil; k, _ = cursor.Next() {
+			if expired(expiry, k, now) {
+				continue
+			}
+			if limit > 0 && len(keys) == limit {
+				next = keys[len(keys)-1]
+				break
+			}
+			keys = append(keys, string(k))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return keys, next, nil
+}
+
+// Call fn for every entry with start <= key < end, in key order, within a
+// single read transaction. An empty end means to the end of the store.
+// raw is only valid until fn returns. A non-nil error from fn stops
+// iteration and is returned.
+func (kvs *KVStore) Range(start, end string, fn func(key string, raw []byte) error) error {
+	return kvs.view(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(kvs.bucket).Cursor()
+		expiry, now := tx.Bucket(kvs.expiryBucket), time.Now()
+		guard := kvs.readGuard(now)
+		stop := []byte(end)
+		for k, v := cursor.Seek([]byte(start)); k != nil; k, v = cursor.Next() {
+			if end != "" && bytes.Compare(k, stop) >= 0 {
+				break
+			}
+			if err := guard.check(); err != nil {
+				return err
+			}
+			if expired(expiry, k, now) {
+				continue
+			}
+			if err := fn(string(k), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Like Range, but visits the same entries from largest key to smallest.
+func (kvs *KVStore) RangeReverse(start, end string, fn func(key string, raw []byte) error) error {
+	return kvs.view(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(kvs.bucket).Cursor()
+		expiry, now := tx.Bucket(kvs.expiryBucket), time.Now()
+		var k, v []byte
+		if end == "" {
+			k, v = cursor.Last()
+		} else if k, v = cursor.Seek([]byte(end)); k == nil {
+			k, v = cursor.Last()
+		} else {
+			// Seek lands on the first key >= end, which is excluded.
+			k, v = cursor.Prev()
+		}
+		first := []byte(start)
+		guard := kvs.readGuard(now)
+		for ; k != nil && bytes.Compare(k, first) >= 0; k, v = cursor.Prev() {
+			if err := guard.check(); err != nil {
+				return err
+			}
+			if expired(expiry, k, now) {
+				continue
+			}
+			if err := fn(string(k), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Like ForEach, but from the largest key to the smallest.
+func (kvs *KVStore) ForEachReverse(fn func(key string, raw []byte) error) error {
+	return kvs.RangeReverse("", "", fn)
+}
+
+// Return the smallest key in the store, or ErrNotFound if it is empty.
+func (kvs *KVStore) First() (string, error) {
+	return kvs.edge(func(c *bolt.Cursor) ([]byte, []byte) { return c.First() }, (*bolt.Cursor).Next)
+}
+
+// Return the largest key in the store, or ErrNotFound if it is empty.
+func (kvs *KVStore) Last() (string, error) {
+	return kvs.edge(func(c *bolt.Cursor) ([]byte, []byte) { return c.Last() }, (*bolt.Cursor).Prev)
+}
+
+// Return the first unexpired key found by calling start and then step.
+func (kvs *KVStore) edge(start, step func(*bolt.Cursor) ([]byte, []byte)) (string, error) {
+	key := ""
+	err := kvs.view(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(kvs.bucket).Cursor()
+		expiry, now := tx.Bucket(kvs.expiryBucket), time.Now()
+		for k, _ := start(cursor); k != nil; k, _ = step(cursor) {
+			if !expired(expiry, k, now) {
+				key = string(k)
+				return nil
+			}
+		}
+		return ErrNotFound
+	})
+	return key, err
+}
+
+// An Entry is one key and its stored bytes, as returned by Entries.
+type Entry struct {
+	Key string
+	Raw []byte
+}
+
+// Return every live entry in key order, collected in one read transaction.
+// Raw is a copy of the stored bytes, safe to keep; decode it with the
+// store's Codec. The whole store is held in memory, so this suits small
+// stores such as configuration; page through bigger ones with Scan or
+// walk them with ForEach instead.
+func (kvs *KVStore) Entries() ([]Entry, error) {
+	entries := []Entry{}
+	err := kvs.view(func(tx *bolt.Tx) error {
+		entries = entries[:0]
+		return kvs.forEach(tx, time.Now(), func(key string, raw []byte) error {
+			entries = append(entries, Entry{Key: key, Raw: append([]byte(nil), raw...)})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Call fn for every entry whose key starts with prefix, in key order,
+// within a single read transaction, with the value decoded into a fresh
+// value of proto's type: pass a Widget{} and fn gets Widgets. An entry
+// that won't decode stops iteration with its *DecodeError; use
+// ForEachTypedSkipErrors to pass over such entries instead. A non-nil
+// error from fn stops iteration and is returned.
+func (kvs *KVStore) ForEachTyped(prefix string, proto interface{}, fn func(key string, value interface{}) error) error {
+	return kvs.forEachTyped(prefix, proto, false, fn)
+}
+
+// Like ForEachTyped, but entries that won't decode into proto's type are
+// skipped rather than stopping iteration.
+func (kvs *KVStore) ForEachTypedSkipErrors(prefix string, proto interface{}, fn func(key string, value interface{}) error) error {
+	return kvs.forEachTyped(prefix, proto, true, fn)
+}
+
+func (kvs *KVStore) forEachTyped(prefix string, proto interface{}, skip bool, fn func(key string, value interface{}) error) error {
+	if proto == nil {
+		return ErrBadValue
+	}
+	t := reflect.TypeOf(proto)
+	return kvs.view(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(kvs.bucket).Cursor()
+		expiry, now := tx.Bucket(kvs.expiryBucket), time.Now()
+		guard := kvs.readGuard(now)
+		p := []byte(prefix)
+		for k, v := cursor.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = cursor.Next() {
+			if err := guard.check(); err != nil {
+				return err
+			}
+			if expired(expiry, k, now) {
+				continue
+			}
+			value := reflect.New(t)
+			if err := kvs.decode(string(k), v, value.Interface()); err != nil {
+				if skip {
+					continue
+				}
+				return err
+			}
+			if err := fn(string(k), value.Elem().Interface()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Return the immediate children of prefix in a store whose keys are
+// "/"-separated paths, in key order: for each live key under prefix, the
+// part after prefix up to and including the next "/", or the rest of the
+// key if there is none. Keys "a/b/c", "a/b/d" and "a/e" under prefix "a/"
+// give "b/" and "e". Each child that is itself a prefix is only visited up
+// to its first live key before skipping past the rest of it, so browsing a
+// wide tree doesn't walk every key beneath it.
+func (kvs *KVStore) Children(prefix string) ([]string, error) {
+	children := []string{}
+	err := kvs.view(func(tx *bolt.Tx) error {
+		children = children[:0]
+		cursor := tx.Bucket(kvs.bucket).Cursor()
+		expiry, now := tx.Bucket(kvs.expiryBucket), time.Now()
+		p := []byte(prefix)
+		for k, _ := cursor.Seek(p); k != nil && bytes.HasPrefix(k, p); {
+			rest := k[len(p):]
+			// A key equal to prefix itself is no child of it.
+			if len(rest) == 0 || expired(expiry, k, now) {
+				k, _ = cursor.Next()
+				continue
+			}
+			i := bytes.IndexByte(rest, '/')
+			if i < 0 {
+				children = append(children, string(rest))
+				k, _ = cursor.Next()
+				continue
+			}
+			child := string(rest[:i+1])
+			children = append(children, child)
+			// '0' follows '/', so this is the first key past the child.
+			k, _ = cursor.Seek([]byte(prefix + child[:i] + "0"))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return children, nil
+}
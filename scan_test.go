@@ -0,0 +1,22 @@
+package kvs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestChildrenSkipsThePrefixItself(t *testing.T) {
+	kvs := openTest(t)
+	for _, key := range []string{"a/", "a/b/c", "a/b/d", "a/e", "b"} {
+		if err := kvs.Put(key, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	children, err := kvs.Children("a/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"b/", "e"}; !reflect.DeepEqual(children, want) {
+		t.Errorf("Children = %q, want %q", children, want)
+	}
+}
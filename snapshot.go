@@ -0,0 +1,91 @@
+package kvs
+
+import (
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// A Snapshot is a point-in-time copy of a store's entries, for iterating
+// at leisure without keeping a transaction open:
+//
+//	snap, err := kvs.Snapshot()
+//	...
+//	defer snap.Close()
+//	for snap.Next() {
+//		err := snap.Value(&v)
+//		...
+//	}
+//
+// Writes made after Snapshot returns are never seen, and the store isn't
+// locked while the Snapshot is in use. A Snapshot is not safe for use by
+// several goroutines at once.
+type Snapshot struct {
+	kvs     *KVStore
+	entries []snapshotEntry
+	// Index of the current entry; -1 before the first call to Next.
+	i int
+}
+
+type snapshotEntry struct {
+	key  string
+	data []byte
+}
+
+// Copy every live entry in the store, in key order, in one read
+// transaction. The whole store is held in memory until the Snapshot is
+// closed, so this suits stores that fit comfortably in RAM; for bigger
+// ones page through with Scan, at the cost of seeing concurrent writes.
+func (kvs *KVStore) Snapshot() (*Snapshot, error) {
+	snap := &Snapshot{kvs: kvs, i: -1}
+	err := kvs.view(func(tx *bolt.Tx) error {
+		snap.entries = nil
+		cursor := tx.Bucket(kvs.bucket).Cursor()
+		expiry, now := tx.Bucket(kvs.expiryBucket), time.Now()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			if expired(expiry, k, now) {
+				continue
+			}
+			snap.entries = append(snap.entries, snapshotEntry{
+				key:  string(k),
+				data: append([]byte(nil), v...),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// Advance to the next entry, reporting whether there is one.
+func (s *Snapshot) Next() bool {
+	if s.i < len(s.entries) {
+		s.i++
+	}
+	return s.i < len(s.entries)
+}
+
+// Return the current entry's key.
+func (s *Snapshot) Key() string {
+	return s.entries[s.i].key
+}
+
+// Decode the current entry into value, which must be pointer-typed.
+func (s *Snapshot) Value(value interface{}) error {
+	e := s.entries[s.i]
+	return s.kvs.decode(e.key, e.data, value)
+}
+
+// Return the current entry's bytes as stored. They belong to the
+// Snapshot and stay valid until it is closed.
+func (s *Snapshot) Raw() []byte {
+	return s.entries[s.i].data
+}
+
+// Release the copied entries. Next returns false afterwards.
+func (s *Snapshot) Close() error {
+	s.entries, s.i = nil, 0
+	return nil
+}
@@ -0,0 +1,153 @@
+package kvs
+
+import (
+	"os"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// BucketStats describes how the store's bucket uses its pages. The fields
+// mirror bolt.BucketStats.
+type BucketStats bolt.BucketStats
+
+// Return page and space usage for the store's bucket. Comparing
+// LeafInuse with LeafAlloc gives a feel for fragmentation.
+func (kvs *KVStore) Stats() (BucketStats, error) {
+	var stats BucketStats
+	err := kvs.view(func(tx *bolt.Tx) error {
+		stats = BucketStats(tx.Bucket(kvs.bucket).Stats())
+		return nil
+	})
+	return stats, err
+}
+
+// Verify the structure of the whole database file, as Bolt's own
+// consistency check does, and return every problem found. An empty slice
+// means the file is healthy. The check runs in a read transaction, so
+// reads and writes carry on meanwhile, but it visits every page and can
+// take a while on a big file.
+func (kvs *KVStore) Check() []error {
+	if err := kvs.lockDB(); err != nil {
+		return []error{err}
+	}
+	defer kvs.root.dbMu.RUnlock()
+	errs := []error{}
+	err := kvs.db.View(func(tx *bolt.Tx) error {
+		for err := range tx.Check() {
+			errs = append(errs, err)
+		}
+		return nil
+	})
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// Return the size in bytes of the database file on disk. Bolt grows the
+// file ahead of need and never shrinks it, so this is usually more than the
+// data takes up; Compact reclaims the slack. The file is shared with any
+// namespaces.
+func (kvs *KVStore) FileSize() (int64, error) {
+	info, err := os.Stat(kvs.Path())
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// Return the number of bytes stored for the value at key, as GetRaw
+// would return them, after the Codec and any compression or encryption,
+// without decoding or copying them. Bolt's own per-entry overhead isn't
+// counted. Walk the keys with ForEach and call this on each to find the
+// biggest entries. No matching values returns ErrNotFound.
+func (kvs *KVStore) ValueSize(key string) (int, error) {
+	var n int
+	err := kvs.view(func(tx *bolt.Tx) error {
+		v, err := kvs.lookup(tx, key)
+		n = len(v)
+		return err
+	})
+	if err == errExpired {
+		kvs.purge(key)
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Meta key written and deleted again by Ping.
+const pingKey = "ping"
+
+// Check that the store can still be written, by writing a probe entry to
+// its bookkeeping bucket and deleting it again in one committed
+// transaction. Holding the file open proves little; this surfaces a disk
+// that has filled up or a filesystem remounted read-only. User keys are
+// never touched. Stores opened WithReadOnly return ErrReadOnly.
+func (kvs *KVStore) Ping() error {
+	return kvs.update(func(tx *bolt.Tx) error {
+		if err := kvs.putMeta(tx, pingKey, encodeExpiry(time.Now())); err != nil {
+			return err
+		}
+		return tx.Bucket(kvs.metaBucket).Delete([]byte(pingKey))
+	})
+}
+
+// Info gathers a store's metrics in one JSON-serializable value, for a
+// debug page or a metrics scrape.
+type Info struct {
+	Path string `json:"path"`
+	// Of the whole file, shared with any namespaces.
+	FileSize int64 `json:"file_size"`
+	// Entries in the store, counting expired ones not yet deleted.
+	Keys  int         `json:"keys"`
+	Stats BucketStats `json:"stats"`
+	// Nil unless the store was opened WithReadCache.
+	Cache *CacheStats `json:"cache,omitempty"`
+	// Nil unless the store was opened WithSweepInterval.
+	Sweep *SweepStats `json:"sweep,omitempty"`
+}
+
+// SweepStats counts the background sweeper's work since Open.
+type SweepStats struct {
+	// Sweeps run over the store and its namespaces.
+	Runs uint64 `json:"runs"`
+	// Expired entries deleted from this store.
+	Deleted uint64 `json:"deleted"`
+}
+
+// Return the store's metrics in one Info. Keys and Stats come from one
+// read transaction and the counters are read lock-free, so this is cheap
+// enough to call on every scrape, though gathering Stats does walk the
+// bucket's pages.
+func (kvs *KVStore) Info() (Info, error) {
+	info := Info{Path: kvs.Path()}
+	size, err := kvs.FileSize()
+	if err != nil {
+		return Info{}, err
+	}
+	info.FileSize = size
+	err = kvs.view(func(tx *bolt.Tx) error {
+		info.Stats = BucketStats(tx.Bucket(kvs.bucket).Stats())
+		info.Keys = info.Stats.KeyN
+		return nil
+	})
+	if err != nil {
+		return Info{}, err
+	}
+	if kvs.cache != nil {
+		stats := kvs.CacheStats()
+		info.Cache = &stats
+	}
+	if kvs.opts.sweepInterval > 0 {
+		info.Sweep = &SweepStats{
+			Runs:    atomic.LoadUint64(&kvs.root.sweeps),
+			Deleted: atomic.LoadUint64(&kvs.swept),
+		}
+	}
+	return info, nil
+}
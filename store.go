@@ -0,0 +1,34 @@
+package kvs
+
+// Store is a typed view over a KVStore for values of type T, so callers
+// don't need to pass pointers to Get. The KVStore stays usable directly
+// for entries of other types.
+type Store[T any] struct {
+	kvs *KVStore
+}
+
+// Wrap kvs in a Store for values of type T.
+func NewStore[T any](kvs *KVStore) *Store[T] {
+	return &Store[T]{kvs: kvs}
+}
+
+// Return the value stored at key. Missing keys return the zero value and
+// ErrNotFound.
+func (s *Store[T]) Get(key string) (T, error) {
+	var value T
+	if err := s.kvs.Get(key, &value); err != nil {
+		var zero T
+		return zero, err
+	}
+	return value, nil
+}
+
+// Puts value into the underlying KVStore at key.
+func (s *Store[T]) Put(key string, value T) error {
+	return s.kvs.Put(key, value)
+}
+
+// Delete key from the underlying KVStore.
+func (s *Store[T]) Delete(key string) error {
+	return s.kvs.Delete(key)
+}
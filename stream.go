@@ -0,0 +1,243 @@
+package kvs
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// One line of the StreamChanges format.
+type changeRecord struct {
+	// The key's change log sequence number, or for a "start" record the
+	// latest one when the stream began; omitted without WithChangeLog.
+	Seq uint64 `json:"seq,omitempty"`
+	// "start", "put" or "delete".
+	Op  string `json:"op"`
+	Key string `json:"key,omitempty"`
+	// A put's stored bytes, base64-encoded in the JSON.
+	Raw     []byte     `json:"raw,omitempty"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// Write the store's committed changes to w as JSON lines until stop is
+// called, for ApplyChanges to replay on a replica. Each record holds a
+// changed key's state as of when it is written, so replaying converges on
+// the store even if records for a key collapse or come out of order. To
+// seed a replica, start streaming, then copy existing entries with CopyTo.
+func (kvs *KVStore) StreamChanges(w io.Writer) (stop func(), err error) {
+	var start uint64
+	err = kvs.view(func(tx *bolt.Tx) error {
+		start = kvs.changeSeq(tx)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s := &stream{wake: make(chan struct{}, 1), done: make(chan struct{})}
+	kvs.watch.addStream(s)
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(changeRecord{Seq: start, Op: "start"}); err != nil {
+		kvs.watch.removeStream(s)
+		return nil, err
+	}
+	quit := make(chan struct{})
+	go func() {
+		defer close(s.done)
+		for {
+			select {
+			case <-s.wake:
+			case <-quit:
+				kvs.emit(enc, s.take())
+				return
+			}
+			if err := kvs.emit(enc, s.take()); err != nil {
+				kvs.watch.removeStream(s)
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			kvs.watch.removeStream(s)
+			close(quit)
+			<-s.done
+		})
+	}, nil
+}
+
+// Write a record of the current state of each of keys, read in one
+// transaction.
+func (kvs *KVStore) emit(enc *json.Encoder, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	var records []changeRecord
+	err := kvs.view(func(tx *bolt.Tx) error {
+		records = records[:0]
+		expiry, now := tx.Bucket(kvs.expiryBucket), time.Now()
+		for _, key := range keys {
+			r := changeRecord{Seq: kvs.keySeq(tx, key), Op: "delete", Key: key}
+			if v := tx.Bucket(kvs.bucket).Get([]byte(key)); v != nil && !expired(expiry, []byte(key), now) {
+				r.Op, r.Raw = "put", append([]byte(nil), v...)
+				if t, ok := expiresAt(expiry, []byte(key)); ok {
+					r.Expires = &t
+				}
+			}
+			records = append(records, r)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Return the latest change log sequence number within tx, or 0.
+func (kvs *KVStore) changeSeq(tx *bolt.Tx) uint64 {
+	if meta := tx.Bucket(kvs.metaBucket); meta != nil {
+		if log := meta.Bucket([]byte(changeLogBucket)); log != nil {
+			return log.Sequence()
+		}
+	}
+	return 0
+}
+
+// Return key's latest change log sequence number within tx, or 0.
+func (kvs *KVStore) keySeq(tx *bolt.Tx, key string) uint64 {
+	if meta := tx.Bucket(kvs.metaBucket); meta != nil {
+		if seqs := meta.Bucket([]byte(changeSeqBucket)); seqs != nil {
+			if v := seqs.Get([]byte(key)); len(v) == 8 {
+				return binary.BigEndian.Uint64(v)
+			}
+		}
+	}
+	return 0
+}
+
+// A stream collects the keys changed since its goroutine last looked, in
+// the order they first changed. Unlike a watcher's channel it never drops
+// any, so a slow writer lets keys pile up in memory, one per key; writers
+// never wait on it.
+type stream struct {
+	mu      sync.Mutex
+	keys    []string
+	pending map[string]bool
+	// Signalled, without blocking, as keys are added.
+	wake chan struct{}
+	done chan struct{}
+}
+
+func (s *stream) add(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending[key] {
+		return
+	}
+	if s.pending == nil {
+		s.pending = make(map[string]bool)
+	}
+	s.pending[key] = true
+	s.keys = append(s.keys, key)
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *stream) take() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := s.keys
+	s.keys, s.pending = nil, nil
+	return keys
+}
+
+// How many operations ApplyChanges writes per transaction.
+const applyBatchSize = 1000
+
+// Replay a stream written by StreamChanges from r, in batches of
+// transactions, returning how many put and delete records were applied.
+// Puts store the raw bytes and expiry exactly, replacing what was there;
+// ones whose expiry has passed delete the key instead. Deletes of missing
+// keys are fine. Since every record carries a key's whole state, applying
+// the same stream twice, or overlapping parts of it, leaves the same
+// result. If r turns out to be malformed part way, or a write fails, the
+// batches already applied stay and their count is returned with the
+// error.
+func (kvs *KVStore) ApplyChanges(r io.Reader) (n int, err error) {
+	defer func() { kvs.logOp("ApplyChanges", "", err) }()
+	var batch []changeRecord
+	flush := func() error {
+		err := kvs.update(func(tx *bolt.Tx) error {
+			now := time.Now()
+			for _, r := range batch {
+				if r.Op == "delete" || r.Expires != nil && !r.Expires.After(now) {
+					if _, err := kvs.delete(tx, r.Key); err != nil {
+						return err
+					}
+					continue
+				}
+				var expiresAt []byte
+				if r.Expires != nil {
+					expiresAt = encodeExpiry(*r.Expires)
+				}
+				if err := kvs.write(tx, r.Key, r.Raw); err != nil {
+					return err
+				}
+				if err := kvs.setExpiry(tx, r.Key, expiresAt); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		n += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+	dec := json.NewDecoder(r)
+	for {
+		var r changeRecord
+		if err := dec.Decode(&r); err == io.EOF {
+			break
+		} else if err != nil {
+			return n, err
+		}
+		switch r.Op {
+		case "start":
+			continue
+		case "put":
+			if r.Raw == nil {
+				r.Raw = []byte{}
+			}
+		case "delete":
+		default:
+			return n, fmt.Errorf("kvs: unknown change op %q", r.Op)
+		}
+		if batch = append(batch, r); len(batch) == applyBatchSize {
+			if err := flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	if len(batch) > 0 {
+		if err := flush(); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
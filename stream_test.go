@@ -0,0 +1,158 @@
+package kvs
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A bytes.Buffer safe to write from the stream goroutine while the test
+// reads it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf.Bytes()...)
+}
+
+// Replay everything streamed from primary into a fresh replica and check
+// the two hold the same keys.
+func checkReplica(t *testing.T, primary *KVStore, stream []byte) {
+	t.Helper()
+	replica := openTest(t)
+	if _, err := replica.ApplyChanges(bytes.NewReader(stream)); err != nil {
+		t.Fatal(err)
+	}
+	want, err := primary.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := replica.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("replica has %q, primary %q", got, want)
+	}
+}
+
+// Wait until the stream written to buf contains s.
+func waitFor(t *testing.T, buf *syncBuffer, s string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !bytes.Contains(buf.Bytes(), []byte(s)) {
+		if time.Now().After(deadline) {
+			t.Fatalf("stream never wrote %s", s)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestStreamChangesSeesClear(t *testing.T) {
+	kvs := openTest(t)
+	var buf syncBuffer
+	stop, err := kvs.StreamChanges(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kvs.Put("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	// Make sure the put has gone out before the clear, as on a busy store.
+	waitFor(t, &buf, `"key":"a"`)
+	if err := kvs.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if err := kvs.Put("b", 2); err != nil {
+		t.Fatal(err)
+	}
+	stop()
+	checkReplica(t, kvs, buf.Bytes())
+}
+
+func TestStreamChangesConvergesUnderConcurrentWrites(t *testing.T) {
+	kvs := openTest(t)
+	if err := kvs.Put("before", 0); err != nil {
+		t.Fatal(err)
+	}
+	var buf syncBuffer
+	stop, err := kvs.StreamChanges(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				key := fmt.Sprintf("k%d", i%20)
+				var err error
+				if i%7 == 0 {
+					err = kvs.Delete(key)
+					if err == ErrNotFound {
+						err = nil
+					}
+				} else {
+					err = kvs.PutWithTTL(key, g*1000+i, time.Hour)
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	stop()
+
+	// Seed the replica with what was there before streaming began, as
+	// CopyTo would, then replay the stream twice: it must be idempotent.
+	replica := openTest(t)
+	if _, err := kvs.CopyTo(replica, "before"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := replica.ApplyChanges(bytes.NewReader(buf.Bytes())); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, _ := kvs.Entries()
+	got, _ := replica.Entries()
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("replica has %v, primary %v", got, want)
+	}
+	for _, e := range want {
+		if e.Key == "before" {
+			continue
+		}
+		if ttl, err := replica.TTL(e.Key); err != nil || ttl <= 0 {
+			t.Errorf("replica TTL of %s = %v, %v", e.Key, ttl, err)
+		}
+	}
+}
+
+func TestApplyChangesRejectsUnknownOps(t *testing.T) {
+	kvs := openTest(t)
+	stream := `{"op":"put","key":"a","raw":"eA=="}` + "\n" + `{"op":"bogus","key":"b"}` + "\n"
+	if _, err := kvs.ApplyChanges(strings.NewReader(stream)); err == nil {
+		t.Fatal("ApplyChanges accepted an unknown op")
+	}
+	if n, _ := kvs.Count(); n != 0 {
+		t.Errorf("ApplyChanges applied %d records from a bad batch", n)
+	}
+}
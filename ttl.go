@@ -0,0 +1,248 @@
+package kvs
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	ErrBadTTL = errors.New("kvs: bad ttl")
+	// Expiry times live in a sibling bucket named after the store's bucket,
+	// keyed like the entries they belong to, so entries written by Put keep
+	// their plain encoding.
+	expirySuffix = ".ttl"
+)
+
+// Puts an entry into the Key-Value Store that expires after ttl.
+// Once expired, the entry behaves as if it was never stored; it is deleted
+// lazily the next time it is read. ttl must be positive, or NoExpiry for
+// an entry that never expires, overriding any WithDefaultTTL.
+func (kvs *KVStore) PutWithTTL(key string, value interface{}, ttl time.Duration) (err error) {
+	defer func() { kvs.logOp("PutWithTTL", key, err) }()
+	if ttl == NoExpiry {
+		return kvs.putUntil(key, value, time.Time{})
+	}
+	if ttl <= 0 {
+		return ErrBadTTL
+	}
+	return kvs.putUntil(key, value, time.Now().Add(ttl))
+}
+
+// Like PutWithTTL, but the entry expires at the wall-clock time t, such as
+// the end of a billing day. It is swept and hidden from readers exactly as
+// a TTL'd entry is, and TTL reports the time left until t. A t that isn't
+// in the future returns ErrBadTTL and stores nothing, rather than writing
+// an entry that can never be read.
+func (kvs *KVStore) PutExpireAt(key string, value interface{}, t time.Time) (err error) {
+	defer func() { kvs.logOp("PutExpireAt", key, err) }()
+	if !t.After(time.Now()) {
+		return ErrBadTTL
+	}
+	return kvs.putUntil(key, value, t)
+}
+
+// Put value at key, expiring at t or never if t is zero, in one
+// transaction.
+func (kvs *KVStore) putUntil(key string, value interface{}, t time.Time) error {
+	data, err := kvs.encode(key, value)
+	if err != nil {
+		return err
+	}
+	var expiresAt []byte
+	if !t.IsZero() {
+		expiresAt = encodeExpiry(t)
+	}
+	return kvs.update(func(tx *bolt.Tx) error {
+		if err := kvs.write(tx, key, data); err != nil {
+			return err
+		}
+		return kvs.setExpiry(tx, key, expiresAt)
+	})
+}
+
+// Returned by TTL for entries that never expire, and passed to PutWithTTL
+// for one that shouldn't.
+const NoExpiry time.Duration = -1
+
+// Return how long the entry at key has left before it expires, or
+// NoExpiry if it has no TTL. Missing and expired keys return ErrNotFound.
+// Reading the TTL never extends it.
+func (kvs *KVStore) TTL(key string) (time.Duration, error) {
+	ttl := NoExpiry
+	err := kvs.view(func(tx *bolt.Tx) error {
+		if _, err := kvs.lookup(tx, key); err != nil {
+			return err
+		}
+		if t, ok := expiresAt(tx.Bucket(kvs.expiryBucket), []byte(key)); ok {
+			ttl = time.Until(t)
+		}
+		return nil
+	})
+	if err == errExpired {
+		kvs.purge(key)
+		return 0, ErrNotFound
+	} else if err != nil {
+		return 0, err
+	}
+	return ttl, nil
+}
+
+// Expired entries deleted per transaction by the sweeper.
+const sweepBatchSize = 1000
+
+// Run sweepAll every interval until the returned func is called. That func
+// waits for a sweep in progress to finish, so it is safe to close the
+// database straight after.
+func (kvs *KVStore) startSweeper(interval time.Duration) func() {
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				kvs.sweepAll(stop)
+			case <-stop:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stop)
+			<-done
+		})
+	}
+}
+
+// Sweep the root store and its namespaces, bailing out early if stop is
+// closed. Errors are dropped; whatever is left is retried next time.
+func (kvs *KVStore) sweepAll(stop <-chan struct{}) {
+	atomic.AddUint64(&kvs.sweeps, 1)
+	stores := []*KVStore{kvs}
+	kvs.nsMu.Lock()
+	for _, ns := range kvs.namespaces {
+		stores = append(stores, ns)
+	}
+	kvs.nsMu.Unlock()
+	for _, s := range stores {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if n, err := s.sweep(time.Now()); err != nil || n < sweepBatchSize {
+				break
+			}
+		}
+	}
+}
+
+// Delete up to sweepBatchSize entries that expired by now in one
+// transaction, returning how many were deleted. Expiry records whose
+// entry is already gone are dropped too but not counted, so a pass over
+// nothing but those returns less than a full batch and sweepAll moves on.
+func (kvs *KVStore) sweep(now time.Time) (int, error) {
+	n := 0
+	err := kvs.update(func(tx *bolt.Tx) error {
+		n = 0
+		var keys []string
+		expiry := tx.Bucket(kvs.expiryBucket)
+		cursor := expiry.Cursor()
+		for k, v := cursor.First(); k != nil && len(keys) < sweepBatchSize; k, v = cursor.Next() {
+			if t, ok := decodeExpiry(v); ok && !t.After(now) {
+				keys = append(keys, string(k))
+			}
+		}
+		data := tx.Bucket(kvs.bucket)
+		for _, key := range keys {
+			if data.Get([]byte(key)) == nil {
+				if err := expiry.Delete([]byte(key)); err != nil {
+					return err
+				}
+				continue
+			}
+			if _, err := kvs.delete(tx, key); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	if err == nil {
+		atomic.AddUint64(&kvs.swept, uint64(n))
+	}
+	return n, err
+}
+
+// Delete key if it is still expired. Errors are ignored; a key that
+// can't be purged now is still hidden from readers and retried later.
+func (kvs *KVStore) purge(key string) {
+	if kvs.readOnly {
+		return
+	}
+	kvs.update(func(tx *bolt.Tx) error {
+		k := []byte(key)
+		if expiry := tx.Bucket(kvs.expiryBucket); expired(expiry, k, time.Now()) {
+			b := tx.Bucket(kvs.bucket)
+			if err := kvs.reindex(tx, key, b.Get(k), nil); err != nil {
+				return err
+			}
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+			kvs.cache.invalidate(tx, key)
+			kvs.changed(tx, OpDelete, key)
+			if err := kvs.logChange(tx, key); err != nil {
+				return err
+			}
+			return expiry.Delete(k)
+		}
+		return nil
+	})
+}
+
+// Store the encoded expiry time for key within tx, or clear it if
+// expiresAt is empty.
+func (kvs *KVStore) setExpiry(tx *bolt.Tx, key string, expiresAt []byte) error {
+	if len(expiresAt) == 0 {
+		return tx.Bucket(kvs.expiryBucket).Delete([]byte(key))
+	}
+	return tx.Bucket(kvs.expiryBucket).Put([]byte(key), expiresAt)
+}
+
+// Report whether key has an expiry time at or before now.
+// The expiry bucket may be missing from files opened read-only.
+func expired(expiry *bolt.Bucket, key []byte, now time.Time) bool {
+	t, ok := expiresAt(expiry, key)
+	return ok && !t.After(now)
+}
+
+// Return the expiry time stored for key, if any.
+func expiresAt(expiry *bolt.Bucket, key []byte) (time.Time, bool) {
+	if expiry == nil {
+		return time.Time{}, false
+	}
+	return decodeExpiry(expiry.Get(key))
+}
+
+func decodeExpiry(v []byte) (time.Time, bool) {
+	if len(v) != 8 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(v))), true
+}
+
+func encodeExpiry(t time.Time) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(t.UnixNano()))
+	return b
+}
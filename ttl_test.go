@@ -0,0 +1,55 @@
+package kvs
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestSweepDropsOrphanedExpiryRecords(t *testing.T) {
+	kvs := openTest(t)
+	past := encodeExpiry(time.Now().Add(-time.Hour))
+	err := kvs.update(func(tx *bolt.Tx) error {
+		expiry := tx.Bucket(kvs.expiryBucket)
+		for i := 0; i < sweepBatchSize+500; i++ {
+			if err := expiry.Put([]byte(fmt.Sprintf("orphan%05d", i)), past); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kvs.PutWithTTL("live", "v", time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		kvs.sweepAll(nil)
+		kvs.sweepAll(nil)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("sweepAll didn't return")
+	}
+
+	if got := kvs.swept; got != 1 {
+		t.Fatalf("swept %d entries, want 1", got)
+	}
+	err = kvs.view(func(tx *bolt.Tx) error {
+		if n := tx.Bucket(kvs.expiryBucket).Stats().KeyN; n != 0 {
+			t.Errorf("%d expiry records left", n)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
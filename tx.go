@@ -0,0 +1,238 @@
+package kvs
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Tx is a transaction handed to View or Update. Every read made through it
+// sees the same consistent snapshot of the store, plus its own writes. A Tx
+// must not be used after the function it was passed to returns.
+type Tx interface {
+	// Like KVStore.Get.
+	Get(key string, value interface{}) error
+	// Like KVStore.Has.
+	Has(key string) (bool, error)
+	// Like KVStore.ForEach. A read timeout counts from the start of the
+	// transaction.
+	ForEach(fn func(key string, raw []byte) error) error
+	// Like KVStore.Put. Fails with bolt.ErrTxNotWritable inside View.
+	Put(key string, value interface{}) error
+	// Like KVStore.Delete. Fails with bolt.ErrTxNotWritable inside View.
+	Delete(key string) error
+}
+
+// Run fn in a read-only transaction. The error from fn is returned.
+func (kvs *KVStore) View(fn func(Tx) error) error {
+	t := kvs.newTxn(nil)
+	err := kvs.view(func(tx *bolt.Tx) error {
+		t.tx = tx
+		return fn(t)
+	})
+	t.purge()
+	return err
+}
+
+// Run fn in a read-write transaction, committing if fn returns nil and
+// rolling back every write it made otherwise. The error from fn is
+// returned. Other writers wait until fn returns.
+func (kvs *KVStore) Update(fn func(Tx) error) (err error) {
+	defer func() { kvs.logOp("Update", "", err) }()
+	t := kvs.newTxn(nil)
+	err = kvs.update(func(tx *bolt.Tx) error {
+		t.tx = tx
+		return fn(t)
+	})
+	t.purge()
+	return err
+}
+
+// Returned by the fn passed to UpdateWithRetry to have it run again.
+var ErrConflict = errors.New("kvs: transaction conflict")
+
+// Delays between UpdateWithRetry attempts: the first, doubling each time
+// up to the most.
+const (
+	retryBackoff    = time.Millisecond
+	retryBackoffMax = 100 * time.Millisecond
+)
+
+// Like Update, but if fn returns an error matching ErrConflict its writes
+// are rolled back and it runs again in a fresh transaction, up to
+// maxAttempts times in all; values below 1 count as 1. Bolt serializes
+// writers, so nothing conflicts on its own: fn decides, typically when a
+// value it read doesn't satisfy a precondition that another process is
+// expected to settle, as in an optimistic read-then-write. Between
+// attempts the write lock is released and UpdateWithRetry sleeps, for 1ms
+// after the first and twice as long after each one since, up to 100ms. If
+// every attempt conflicts, the last ErrConflict is returned. Other errors
+// from fn end it straight away.
+func (kvs *KVStore) UpdateWithRetry(maxAttempts int, fn func(Tx) error) (err error) {
+	backoff := retryBackoff
+	for attempt := 1; ; attempt++ {
+		err = kvs.Update(fn)
+		if !errors.Is(err, ErrConflict) || attempt >= maxAttempts {
+			return err
+		}
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > retryBackoffMax {
+			backoff = retryBackoffMax
+		}
+	}
+}
+
+// Where an UpdateWithTimeout stands: still running, finished in time and
+// committing, or given up on.
+const (
+	txRunning int32 = iota
+	txFinished
+	txTimedOut
+)
+
+// Like Update, but wait at most d for fn, counting any wait for the write
+// lock, and return ErrTimeout once d has passed. Bolt can't interrupt a
+// transaction, so fn runs on in its own goroutine after the timeout, still
+// holding the write lock, and its writes are rolled back only once it
+// returns; fn should check for itself whether to give up. What the
+// timeout guarantees is that the caller stops waiting, and that nothing fn
+// wrote is committed after ErrTimeout has been returned. If fn finishes in
+// time, UpdateWithTimeout waits for the commit and returns its result even
+// if that takes past d.
+func (kvs *KVStore) UpdateWithTimeout(d time.Duration, fn func(Tx) error) error {
+	state := txRunning
+	done := make(chan error, 1)
+	go func() {
+		done <- kvs.Update(func(tx Tx) error {
+			if atomic.LoadInt32(&state) == txTimedOut {
+				return ErrTimeout
+			}
+			err := fn(tx)
+			if !atomic.CompareAndSwapInt32(&state, txRunning, txFinished) {
+				return ErrTimeout
+			}
+			return err
+		})
+	}()
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		if atomic.CompareAndSwapInt32(&state, txRunning, txTimedOut) {
+			return ErrTimeout
+		}
+		return <-done
+	}
+}
+
+// A Txn is a transaction: the Tx handed to View and Update, or one
+// started with Begin and ended by hand.
+type Txn struct {
+	kvs *KVStore
+	tx  *bolt.Tx
+	// When the transaction began, for WithReadTimeout.
+	start time.Time
+	// Expired keys seen while reading, purged once tx is closed.
+	stale []string
+}
+
+// Return a Txn for tx, which the caller may fill in later, starting its
+// read timeout now.
+func (kvs *KVStore) newTxn(tx *bolt.Tx) *Txn {
+	return &Txn{kvs: kvs, tx: tx, start: time.Now()}
+}
+
+func (t *Txn) Get(key string, value interface{}) error {
+	err := t.kvs.get(t.tx, key, value)
+	if err == errExpired {
+		t.stale = append(t.stale, key)
+		return ErrNotFound
+	}
+	return err
+}
+
+func (t *Txn) Has(key string) (bool, error) {
+	return t.kvs.has(t.tx, key), nil
+}
+
+func (t *Txn) ForEach(fn func(key string, raw []byte) error) error {
+	return t.kvs.forEach(t.tx, t.start, fn)
+}
+
+func (t *Txn) Put(key string, value interface{}) error {
+	data, err := t.kvs.encode(key, value)
+	if err != nil {
+		return err
+	}
+	return t.kvs.put(t.tx, key, data)
+}
+
+func (t *Txn) Delete(key string) error {
+	found, err := t.kvs.delete(t.tx, key)
+	if err == nil && !found {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (t *Txn) purge() {
+	for _, key := range t.stale {
+		t.kvs.purge(key)
+	}
+	t.stale = nil
+}
+
+// Start a transaction, writable or not, that stays open until Commit or
+// Rollback, for work spread over several calls. It sees the same store
+// and Codec as the other methods. The rule is strict: every Txn must be
+// ended, on every path, usually with a deferred Rollback. Bolt allows one
+// writable transaction at a time, so a forgotten writable Txn blocks every
+// other writer for good, and a forgotten read-only one eventually stalls
+// writers that need to grow the file. A Txn belongs to the goroutine that
+// began it, which must not call other write methods on the store while a
+// writable Txn is open.
+func (kvs *KVStore) Begin(writable bool) (*Txn, error) {
+	if writable && kvs.readOnly {
+		return nil, ErrReadOnly
+	}
+	if err := kvs.prepare(); err != nil {
+		return nil, err
+	}
+	if err := kvs.lockDB(); err != nil {
+		return nil, err
+	}
+	tx, err := kvs.db.Begin(writable)
+	kvs.root.dbMu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	return kvs.newTxn(tx), nil
+}
+
+// End a transaction from Begin, keeping its writes. Ending a read-only
+// Txn this way is the same as Rollback. Returns bolt.ErrTxClosed if it
+// has already ended.
+func (t *Txn) Commit() (err error) {
+	if !t.tx.Writable() {
+		return t.Rollback()
+	}
+	defer func() { t.kvs.logOp("Commit", "", err) }()
+	err = t.tx.Commit()
+	t.purge()
+	return err
+}
+
+// End a transaction from Begin, discarding its writes. Rolling back a Txn
+// already ended returns bolt.ErrTxClosed and does nothing else, so a
+// deferred Rollback after Commit is harmless.
+func (t *Txn) Rollback() error {
+	err := t.tx.Rollback()
+	if err == nil {
+		t.purge()
+	}
+	return err
+}
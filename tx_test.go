@@ -0,0 +1,71 @@
+package kvs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUpdateWithTimeoutCommitsInTime(t *testing.T) {
+	kvs := openTest(t)
+	err := kvs.UpdateWithTimeout(time.Second, func(tx Tx) error {
+		return tx.Put("k", 1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := kvs.Has("k"); !ok {
+		t.Error("write made in time was not committed")
+	}
+}
+
+func TestUpdateWithTimeoutNeverCommitsAfterTimingOut(t *testing.T) {
+	kvs := openTest(t)
+	release, finished := make(chan struct{}), make(chan struct{})
+	start := time.Now()
+	err := kvs.UpdateWithTimeout(10*time.Millisecond, func(tx Tx) error {
+		defer close(finished)
+		if err := tx.Put("late", 1); err != nil {
+			return err
+		}
+		<-release
+		return nil
+	})
+	if err != ErrTimeout {
+		t.Fatalf("UpdateWithTimeout: %v", err)
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("returned after %v", waited)
+	}
+	close(release)
+	<-finished
+	// The rollback happens once fn returns; a write after it waits for it.
+	if err := kvs.Put("next", 1); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := kvs.Has("late"); ok {
+		t.Error("write from a timed out fn was committed")
+	}
+}
+
+func TestUpdateWithTimeoutCountsWaitingForTheLock(t *testing.T) {
+	kvs := openTest(t)
+	txn, err := kvs.Begin(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = kvs.UpdateWithTimeout(10*time.Millisecond, func(tx Tx) error {
+		return tx.Put("blocked", 1)
+	})
+	if err != ErrTimeout {
+		t.Fatalf("UpdateWithTimeout behind a held lock: %v", err)
+	}
+	txn.Rollback()
+	if err := kvs.Put("next", 1); err != nil {
+		t.Fatal(err)
+	}
+	// The queued fn may run once the lock is free, but must not commit.
+	time.Sleep(10 * time.Millisecond)
+	if ok, _ := kvs.Has("blocked"); ok {
+		t.Error("write queued behind the lock was committed after ErrTimeout")
+	}
+}
@@ -0,0 +1,153 @@
+package kvs
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Op is the kind of change an Event reports.
+type Op int
+
+const (
+	OpPut Op = iota + 1
+	OpDelete
+)
+
+func (op Op) String() string {
+	switch op {
+	case OpPut:
+		return "put"
+	case OpDelete:
+		return "delete"
+	}
+	return "unknown"
+}
+
+// An Event reports a committed change to one key.
+type Event struct {
+	Key string
+	Op  Op
+}
+
+// How many events a watcher can fall behind by before it misses some.
+const watchBuffer = 64
+
+// Return a channel of Events for committed changes to keys starting with
+// prefix, and a func that stops watching and closes the channel. Expired
+// entries report OpDelete when they are purged, not when they expire.
+//
+// Events are never delivered while a transaction is held, so writers don't
+// wait on watchers. The flip side is that a watcher more than a few dozen
+// events behind misses events rather than stalling the store.
+func (kvs *KVStore) Watch(prefix string) (<-chan Event, func()) {
+	w := &watcher{prefix: prefix, ch: make(chan Event, watchBuffer)}
+	kvs.watch.add(w)
+	var once sync.Once
+	return w.ch, func() {
+		once.Do(func() {
+			kvs.watch.remove(w)
+		})
+	}
+}
+
+// Queue an event for watchers once tx commits.
+func (kvs *KVStore) changed(tx *bolt.Tx, op Op, key string) {
+	if kvs.watch.active() {
+		tx.OnCommit(func() {
+			kvs.watch.notify(Event{Key: key, Op: op})
+		})
+	}
+}
+
+type watcher struct {
+	prefix string
+	ch     chan Event
+}
+
+type watchers struct {
+	mu   sync.Mutex
+	subs map[*watcher]struct{}
+	// Set up by StreamChanges, which unlike watchers mustn't miss keys.
+	streams map[*stream]struct{}
+	// Mirrors len(subs)+len(streams) so writers can skip the lock when
+	// nobody watches.
+	n int32
+}
+
+func (ws *watchers) active() bool {
+	return atomic.LoadInt32(&ws.n) > 0
+}
+
+func (ws *watchers) add(w *watcher) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.subs == nil {
+		ws.subs = make(map[*watcher]struct{})
+	}
+	ws.subs[w] = struct{}{}
+	atomic.StoreInt32(&ws.n, int32(len(ws.subs)+len(ws.streams)))
+}
+
+func (ws *watchers) remove(w *watcher) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	delete(ws.subs, w)
+	atomic.StoreInt32(&ws.n, int32(len(ws.subs)+len(ws.streams)))
+	close(w.ch)
+}
+
+func (ws *watchers) addStream(s *stream) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.streams == nil {
+		ws.streams = make(map[*stream]struct{})
+	}
+	ws.streams[s] = struct{}{}
+	atomic.StoreInt32(&ws.n, int32(len(ws.subs)+len(ws.streams)))
+}
+
+// Removing a stream more than once is fine.
+func (ws *watchers) removeStream(s *stream) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	delete(ws.streams, s)
+	atomic.StoreInt32(&ws.n, int32(len(ws.subs)+len(ws.streams)))
+}
+
+// Report whether any stream from StreamChanges is listening.
+func (ws *watchers) streaming() bool {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return len(ws.streams) > 0
+}
+
+// Tell streams, but not watchers, that keys changed.
+func (ws *watchers) notifyStreams(keys []string) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	for s := range ws.streams {
+		for _, key := range keys {
+			s.add(key)
+		}
+	}
+}
+
+func (ws *watchers) notify(e Event) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	for w := range ws.subs {
+		if !strings.HasPrefix(e.Key, w.prefix) {
+			continue
+		}
+		select {
+		case w.ch <- e:
+		default:
+		}
+	}
+	for s := range ws.streams {
+		s.add(e.Key)
+	}
+}